@@ -0,0 +1,209 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// labelCounter sums values by label set, for metrics that aggregate many sockets into one series
+type labelCounter struct {
+	counts map[string]float64
+	values map[string][]string
+}
+
+func newLabelCounter() *labelCounter {
+	return &labelCounter{
+		counts: make(map[string]float64),
+		values: make(map[string][]string),
+	}
+}
+
+// add adds n to the series identified by labelValues
+func (l *labelCounter) add(n float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	if _, ok := l.values[key]; !ok {
+		l.values[key] = labelValues
+	}
+	l.counts[key] += n
+}
+
+// collect emits one gauge per label set
+func (l *labelCounter) collect(ch chan<- prometheus.Metric, desc *prometheus.Desc) {
+	for key, count := range l.counts {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, count, l.values[key]...)
+	}
+}
+
+// collectCounters emits one counter per label set, for sums of kernel counters
+func (l *labelCounter) collectCounters(ch chan<- prometheus.Metric, desc *prometheus.Desc) {
+	for key, count := range l.counts {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, count, l.values[key]...)
+	}
+}
+
+// labelMax keeps the largest value by label set
+type labelMax struct {
+	*labelCounter
+}
+
+func newLabelMax() *labelMax {
+	return &labelMax{newLabelCounter()}
+}
+
+// max raises the series identified by labelValues to v
+func (l *labelMax) max(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	if current, ok := l.counts[key]; ok && current >= v {
+		return
+	}
+	l.values[key] = labelValues
+	l.counts[key] = v
+}
+
+// listenerLabels identify a TCP listener in the accept queue metrics
+var listenerLabels = []string{"address", "port", "process_name", "netns"}
+
+// aggregateMetrics describes connection counts that stay bounded no matter how many
+// ephemeral ports are in use
+type aggregateMetrics struct {
+	count         *prometheus.Desc
+	listenPort    *prometheus.Desc
+	listenQueue   *prometheus.Desc
+	listenBacklog *prometheus.Desc
+}
+
+func newAggregateMetrics() *aggregateMetrics {
+	return &aggregateMetrics{
+		count: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "count"),
+			"Number of network connections",
+			[]string{"state", "interface", "vrf", "protocol", "direction", "process_name", "netns"},
+			nil,
+		),
+		listenPort: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "listen_port_count"),
+			"Number of incoming connections per listening port",
+			[]string{"protocol", "port", "state", "process_name", "netns"},
+			nil,
+		),
+		listenQueue: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "listen_queue_length"),
+			"Connections completed by the kernel but not yet accepted by the application, per TCP listener",
+			listenerLabels,
+			nil,
+		),
+		listenBacklog: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "listen_queue_max_length"),
+			"Accept queue capacity (the listen backlog) per TCP listener; requires the netlink backend",
+			listenerLabels,
+			nil,
+		),
+	}
+}
+
+func (m *aggregateMetrics) describe(ch chan<- *prometheus.Desc) {
+	ch <- m.count
+	ch <- m.listenPort
+	ch <- m.listenQueue
+	ch <- m.listenBacklog
+}
+
+// connectionAggregator accumulates the aggregate metrics of one scrape
+type connectionAggregator struct {
+	metrics       *aggregateMetrics
+	count         *labelCounter
+	listenPort    *labelCounter
+	listenQueue   *labelCounter
+	listenBacklog *labelCounter
+}
+
+func (m *aggregateMetrics) newAggregator() *connectionAggregator {
+	return &connectionAggregator{
+		metrics:       m,
+		count:         newLabelCounter(),
+		listenPort:    newLabelCounter(),
+		listenQueue:   newLabelCounter(),
+		listenBacklog: newLabelCounter(),
+	}
+}
+
+// add counts one socket
+func (a *connectionAggregator) add(conn tcpConnection, protocol, direction string) {
+	a.count.add(1, conn.state, conn.sourceInterface, conn.vrf, protocol, direction, conn.processName, conn.netns)
+	if conn.accepted && conn.state != "LISTEN" {
+		a.listenPort.add(1, protocol, conn.sourcePort, conn.state, conn.processName, conn.netns)
+	}
+}
+
+// addListener adds a TCP LISTEN socket's accept queue. SO_REUSEPORT listeners on the
+// same address and port each have their own queue and are summed.
+func (a *connectionAggregator) addListener(conn tcpConnection) {
+	labelValues := []string{conn.sourceAddress, conn.sourcePort, conn.processName, conn.netns}
+	a.listenQueue.add(float64(conn.rxQueue), labelValues...)
+	if conn.listenBacklog > 0 {
+		a.listenBacklog.add(float64(conn.listenBacklog), labelValues...)
+	}
+}
+
+func (a *connectionAggregator) collect(ch chan<- prometheus.Metric) {
+	a.count.collect(ch, a.metrics.count)
+	a.listenPort.collect(ch, a.metrics.listenPort)
+	a.listenQueue.collect(ch, a.metrics.listenQueue)
+	a.listenBacklog.collect(ch, a.metrics.listenBacklog)
+}
+
+// ephemeralPortLabel replaces collapsed ephemeral port numbers
+const ephemeralPortLabel = "ephemeral"
+
+// portRange is the kernel's ephemeral port range (net.ipv4.ip_local_port_range)
+type portRange struct {
+	low  int
+	high int
+}
+
+// readLocalPortRange reads ip_local_port_range, falling back to the kernel default
+func readLocalPortRange() *portRange {
+	r := &portRange{low: 32768, high: 60999}
+	content, err := os.ReadFile(procFilePath("sys", "net", "ipv4", "ip_local_port_range"))
+	if err != nil {
+		log.Printf("Warning: Could not read ip_local_port_range, assuming %d-%d: %v", r.low, r.high, err)
+		return r
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) != 2 {
+		return r
+	}
+	low, errLow := strconv.Atoi(fields[0])
+	high, errHigh := strconv.Atoi(fields[1])
+	if errLow == nil && errHigh == nil && low <= high {
+		r.low, r.high = low, high
+	}
+	return r
+}
+
+// contains reports whether port falls in the range
+func (r *portRange) contains(port string) bool {
+	p, err := strconv.Atoi(port)
+	return err == nil && p >= r.low && p <= r.high
+}
+
+// collapse replaces the ephemeral side of a connection with a placeholder: the local
+// port of connections this end initiated and the peer port of accepted ones. Ports
+// outside the ephemeral range (e.g. a client explicitly bound to a fixed port) are kept,
+// and listeners are never collapsed, even when bound to a port inside the range.
+func (r *portRange) collapse(conn *tcpConnection, protocol string) {
+	if isListener(*conn, protocol) {
+		return
+	}
+	if conn.accepted {
+		if r.contains(conn.destinationPort) {
+			conn.destinationPort = ephemeralPortLabel
+		}
+	} else if r.contains(conn.sourcePort) {
+		conn.sourcePort = ephemeralPortLabel
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestPortRangeContains(t *testing.T) {
+	r := &portRange{low: 32768, high: 60999}
+	tests := []struct {
+		port string
+		want bool
+	}{
+		{"32767", false},
+		{"32768", true},
+		{"45000", true},
+		{"60999", true},
+		{"61000", false},
+		{"0", false},
+		{"", false},
+		{ephemeralPortLabel, false},
+	}
+	for _, tt := range tests {
+		if got := r.contains(tt.port); got != tt.want {
+			t.Errorf("contains(%q) = %v, want %v", tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestPortRangeCollapse(t *testing.T) {
+	r := &portRange{low: 32768, high: 60999}
+	tests := []struct {
+		name     string
+		protocol string
+		conn     tcpConnection
+		wantSrc  string
+		wantDst  string
+	}{
+		{
+			name: "tcp outgoing", protocol: "tcp",
+			conn:    tcpConnection{sourcePort: "32768", destinationPort: "5432", state: "ESTABLISHED"},
+			wantSrc: ephemeralPortLabel, wantDst: "5432",
+		},
+		{
+			name: "tcp outgoing from a fixed port", protocol: "tcp",
+			conn:    tcpConnection{sourcePort: "32767", destinationPort: "5432", state: "ESTABLISHED"},
+			wantSrc: "32767", wantDst: "5432",
+		},
+		{
+			name: "tcp incoming", protocol: "tcp",
+			conn:    tcpConnection{sourcePort: "443", destinationPort: "60999", state: "ESTABLISHED", accepted: true},
+			wantSrc: "443", wantDst: ephemeralPortLabel,
+		},
+		{
+			name: "tcp incoming from a fixed port", protocol: "tcp",
+			conn:    tcpConnection{sourcePort: "443", destinationPort: "61000", state: "ESTABLISHED", accepted: true},
+			wantSrc: "443", wantDst: "61000",
+		},
+		{
+			// A server may listen on a port inside the ephemeral range
+			name: "tcp listener in range", protocol: "tcp",
+			conn:    tcpConnection{sourcePort: "45000", destinationPort: "0", state: "LISTEN"},
+			wantSrc: "45000", wantDst: "0",
+		},
+		{
+			name: "udp outgoing", protocol: "udp",
+			conn:    tcpConnection{sourcePort: "50000", destinationPort: "53", state: "LISTEN"},
+			wantSrc: ephemeralPortLabel, wantDst: "53",
+		},
+		{
+			name: "udp incoming", protocol: "udp",
+			conn:    tcpConnection{sourcePort: "443", destinationPort: "50000", state: "LISTEN", accepted: true},
+			wantSrc: "443", wantDst: ephemeralPortLabel,
+		},
+		{
+			name: "udp listener in range", protocol: "udp",
+			conn:    tcpConnection{sourcePort: "50000", destinationPort: "0", state: "LISTEN"},
+			wantSrc: "50000", wantDst: "0",
+		},
+		{
+			name: "udp unbound", protocol: "udp",
+			conn:    tcpConnection{sourcePort: "0", destinationPort: "0", state: "UNCONN"},
+			wantSrc: "0", wantDst: "0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := tt.conn
+			r.collapse(&conn, tt.protocol)
+			if conn.sourcePort != tt.wantSrc || conn.destinationPort != tt.wantDst {
+				t.Errorf("collapse() ports = %s -> %s, want %s -> %s", conn.sourcePort, conn.destinationPort, tt.wantSrc, tt.wantDst)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// bondStatus is the parsed content of /proc/net/bonding/<bond>
+type bondStatus struct {
+	name               string
+	mode               string
+	miiStatus          string
+	activeSlave        string // active-backup and friends only
+	activeAggregatorID string // 802.3ad only
+	slaves             []bondSlave
+}
+
+// bondSlave is one "Slave Interface" section of a bond
+type bondSlave struct {
+	name             string
+	miiStatus        string
+	speedMbps        int // 0 when unknown
+	duplex           string
+	linkFailureCount float64
+	aggregatorID     string
+}
+
+// readBondingStatus parses every bond under /proc/net/bonding
+func readBondingStatus() ([]bondStatus, error) {
+	bondDir := procFilePath("net", "bonding")
+	entries, err := os.ReadDir(bondDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Bonding driver not loaded
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var bonds []bondStatus
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(procFilePath("net", "bonding", entry.Name()))
+		if err != nil {
+			log.Printf("Warning: Could not read bonding status of %s: %v", entry.Name(), err)
+			countError("bonding", "read")
+			continue
+		}
+		bonds = append(bonds, parseBondingStatus(entry.Name(), string(content)))
+	}
+	return bonds, nil
+}
+
+// parseBondingStatus parses the "Key: value" lines of a bonding status file. Lines before
+// the first "Slave Interface:" describe the bond, the rest belong to the slave above them.
+func parseBondingStatus(name, content string) bondStatus {
+	bond := bondStatus{name: name}
+	var slave *bondSlave
+	inActiveAggregator := false
+
+	for _, line := range strings.Split(content, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		indented := strings.HasPrefix(key, "\t") || strings.HasPrefix(key, " ")
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if key == "Slave Interface" {
+			bond.slaves = append(bond.slaves, bondSlave{name: value})
+			slave = &bond.slaves[len(bond.slaves)-1]
+			continue
+		}
+
+		if slave == nil {
+			switch {
+			case key == "Bonding Mode":
+				bond.mode = value
+			case key == "MII Status":
+				bond.miiStatus = value
+			case key == "Currently Active Slave":
+				bond.activeSlave = value
+			case key == "Active Aggregator Info":
+				inActiveAggregator = true
+			case key == "Aggregator ID" && inActiveAggregator && indented:
+				bond.activeAggregatorID = value
+			}
+			continue
+		}
+
+		switch key {
+		case "MII Status":
+			slave.miiStatus = value
+		case "Speed":
+			// "1000 Mbps" or "Unknown"
+			if mbps, err := strconv.Atoi(strings.TrimSuffix(value, " Mbps")); err == nil {
+				slave.speedMbps = mbps
+			}
+		case "Duplex":
+			slave.duplex = value
+		case "Link Failure Count":
+			if count, err := strconv.ParseFloat(value, 64); err == nil {
+				slave.linkFailureCount = count
+			}
+		case "Aggregator ID":
+			slave.aggregatorID = value
+		}
+	}
+
+	return bond
+}
+
+// bondingCollector exports the health of bonding interfaces, so a bond that degraded to
+// a single leg (and the interface shift it causes in connection metrics) can be alerted on
+type bondingCollector struct {
+	info             *prometheus.Desc
+	up               *prometheus.Desc
+	slaves           *prometheus.Desc
+	slavesUp         *prometheus.Desc
+	slaveInfo        *prometheus.Desc
+	slaveUp          *prometheus.Desc
+	slaveSpeed       *prometheus.Desc
+	slaveLinkFailure *prometheus.Desc
+}
+
+func newBondingCollector() *bondingCollector {
+	return &bondingCollector{
+		info: prometheus.NewDesc(
+			"network_bonding_info",
+			"Bonding interface mode, active slave (active-backup) and active aggregator ID (802.3ad)",
+			[]string{"bond", "mode", "active_slave", "active_aggregator_id"},
+			nil,
+		),
+		up: prometheus.NewDesc(
+			"network_bonding_up",
+			"Whether the bond's MII status is up",
+			[]string{"bond"},
+			nil,
+		),
+		slaves: prometheus.NewDesc(
+			"network_bonding_slaves",
+			"Number of slaves enslaved to the bond",
+			[]string{"bond"},
+			nil,
+		),
+		slavesUp: prometheus.NewDesc(
+			"network_bonding_slaves_up",
+			"Number of slaves whose MII status is up",
+			[]string{"bond"},
+			nil,
+		),
+		slaveInfo: prometheus.NewDesc(
+			"network_bonding_slave_info",
+			"Bonding slave duplex and 802.3ad aggregator ID",
+			[]string{"bond", "slave", "duplex", "aggregator_id"},
+			nil,
+		),
+		slaveUp: prometheus.NewDesc(
+			"network_bonding_slave_up",
+			"Whether the slave's MII status is up",
+			[]string{"bond", "slave"},
+			nil,
+		),
+		slaveSpeed: prometheus.NewDesc(
+			"network_bonding_slave_speed_bytes",
+			"Negotiated slave link speed in bytes per second",
+			[]string{"bond", "slave"},
+			nil,
+		),
+		slaveLinkFailure: prometheus.NewDesc(
+			"network_bonding_slave_link_failures_total",
+			"Number of link failures the bonding driver detected on the slave",
+			[]string{"bond", "slave"},
+			nil,
+		),
+	}
+}
+
+func (c *bondingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.info
+	ch <- c.up
+	ch <- c.slaves
+	ch <- c.slavesUp
+	ch <- c.slaveInfo
+	ch <- c.slaveUp
+	ch <- c.slaveSpeed
+	ch <- c.slaveLinkFailure
+}
+
+func (c *bondingCollector) Collect(ch chan<- prometheus.Metric) {
+	bonds, err := readBondingStatus()
+	if err != nil {
+		log.Printf("Warning: Could not list bonding interfaces: %v", err)
+		countError("bonding", "read")
+		return
+	}
+
+	for _, bond := range bonds {
+		ch <- prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1, bond.name, bond.mode, bond.activeSlave, bond.activeAggregatorID)
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, boolValue(bond.miiStatus == "up"), bond.name)
+
+		slavesUp := 0
+		for _, slave := range bond.slaves {
+			if slave.miiStatus == "up" {
+				slavesUp++
+			}
+			ch <- prometheus.MustNewConstMetric(c.slaveInfo, prometheus.GaugeValue, 1, bond.name, slave.name, slave.duplex, slave.aggregatorID)
+			ch <- prometheus.MustNewConstMetric(c.slaveUp, prometheus.GaugeValue, boolValue(slave.miiStatus == "up"), bond.name, slave.name)
+			ch <- prometheus.MustNewConstMetric(c.slaveLinkFailure, prometheus.CounterValue, slave.linkFailureCount, bond.name, slave.name)
+			if slave.speedMbps > 0 {
+				ch <- prometheus.MustNewConstMetric(c.slaveSpeed, prometheus.GaugeValue, float64(slave.speedMbps)*1000*1000/8, bond.name, slave.name)
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(c.slaves, prometheus.GaugeValue, float64(len(bond.slaves)), bond.name)
+		ch <- prometheus.MustNewConstMetric(c.slavesUp, prometheus.GaugeValue, float64(slavesUp), bond.name)
+	}
+}
+
+// boolValue converts a condition to a 0/1 metric value
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
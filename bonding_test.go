@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBondingStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    bondStatus
+	}{
+		{
+			name: "no slaves",
+			content: "Ethernet Channel Bonding Driver: v6.1.0\n\n" +
+				"Bonding Mode: load balancing (round-robin)\n" +
+				"MII Status: down\n" +
+				"MII Polling Interval (ms): 100\n",
+			want: bondStatus{name: "bond9", mode: "load balancing (round-robin)", miiStatus: "down"},
+		},
+		{
+			// A slave's "Aggregator ID" must not be taken for the bond's active aggregator
+			name: "802.3ad without active aggregator",
+			content: "Bonding Mode: IEEE 802.3ad Dynamic link aggregation\n" +
+				"MII Status: down\n\n" +
+				"Slave Interface: eth0\n" +
+				"MII Status: down\n" +
+				"Speed: Unknown\n" +
+				"Duplex: Unknown\n" +
+				"Link Failure Count: 12\n" +
+				"Aggregator ID: 1\n",
+			want: bondStatus{
+				name:      "bond9",
+				mode:      "IEEE 802.3ad Dynamic link aggregation",
+				miiStatus: "down",
+				slaves: []bondSlave{
+					{name: "eth0", miiStatus: "down", duplex: "Unknown", linkFailureCount: 12, aggregatorID: "1"},
+				},
+			},
+		},
+		{
+			name:    "empty",
+			content: "",
+			want:    bondStatus{name: "bond9"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseBondingStatus("bond9", tt.content); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBondingStatus() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadBondingStatus(t *testing.T) {
+	defer func(root string) { procfsRoot = root }(procfsRoot)
+	procfsRoot = "testdata/proc"
+
+	bonds, err := readBondingStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []bondStatus{
+		{
+			name:        "bond0",
+			mode:        "fault-tolerance (active-backup)",
+			miiStatus:   "up",
+			activeSlave: "eth1",
+			slaves: []bondSlave{
+				{name: "eth0", miiStatus: "down", duplex: "Unknown", linkFailureCount: 3},
+				{name: "eth1", miiStatus: "up", speedMbps: 10000, duplex: "full"},
+			},
+		},
+		{
+			name:               "bond1",
+			mode:               "IEEE 802.3ad Dynamic link aggregation",
+			miiStatus:          "up",
+			activeAggregatorID: "2",
+			slaves: []bondSlave{
+				{name: "ens1f0", miiStatus: "up", speedMbps: 25000, duplex: "full", linkFailureCount: 1, aggregatorID: "1"},
+				{name: "ens1f1", miiStatus: "up", speedMbps: 25000, duplex: "full", aggregatorID: "2"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(bonds, want) {
+		t.Errorf("readBondingStatus() =\n%+v\nwant\n%+v", bonds, want)
+	}
+}
+
+func TestReadBondingStatusNotLoaded(t *testing.T) {
+	defer func(root string) { procfsRoot = root }(procfsRoot)
+	procfsRoot = t.TempDir()
+
+	bonds, err := readBondingStatus()
+	if err != nil || bonds != nil {
+		t.Errorf("readBondingStatus() = %v, %v, want nil, nil", bonds, err)
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ctnetlink constants from linux/netfilter/nfnetlink.h and nfnetlink_conntrack.h
+const (
+	netlinkNetfilter = 12       // NETLINK_NETFILTER
+	ctnetlinkGet     = 1<<8 | 1 // NFNL_SUBSYS_CTNETLINK << 8 | IPCTNL_MSG_CT_GET
+	sizeofNfGenMsg   = 4        // struct nfgenmsg
+	ipsSeenReply     = 1 << 1   // IPS_SEEN_REPLY in CTA_STATUS
+
+	ctaTupleOrig     = 1
+	ctaTupleReply    = 2
+	ctaStatus        = 3
+	ctaProtoinfo     = 4
+	ctaMark          = 8
+	ctaCountersOrig  = 9
+	ctaCountersReply = 10
+	ctaZone          = 18
+
+	ctaTupleIP      = 1 // CTA_TUPLE_IP inside a tuple
+	ctaTupleProto   = 2 // CTA_TUPLE_PROTO inside a tuple
+	ctaIPv4Src      = 1
+	ctaIPv4Dst      = 2
+	ctaIPv6Src      = 3
+	ctaIPv6Dst      = 4
+	ctaProtoNum     = 1
+	ctaProtoSrcPort = 2
+	ctaProtoDstPort = 3
+	ctaProtoinfoTCP = 1 // CTA_PROTOINFO_TCP inside CTA_PROTOINFO
+	ctaTCPState     = 1 // CTA_PROTOINFO_TCP_STATE
+	ctaPackets      = 1 // CTA_COUNTERS_PACKETS
+	ctaBytes        = 2 // CTA_COUNTERS_BYTES
+)
+
+// tcpConntrackStates are the TCP conntrack states in kernel order (enum tcp_conntrack),
+// named as in /proc/net/nf_conntrack
+var tcpConntrackStates = []string{"NONE", "SYN_SENT", "SYN_RECV", "ESTABLISHED", "FIN_WAIT", "CLOSE_WAIT", "LAST_ACK", "TIME_WAIT", "CLOSE", "SYN_SENT2"}
+
+// conntrackProtocols names the IP protocols as /proc/net/nf_conntrack does
+var conntrackProtocols = map[uint8]string{1: "icmp", 6: "tcp", 17: "udp", 33: "dccp", 47: "gre", 58: "icmpv6", 132: "sctp", 136: "udplite"}
+
+// conntrackTuple is one direction of a tracked flow. Ports are empty for protocols without them.
+type conntrackTuple struct {
+	src     net.IP
+	dst     net.IP
+	srcPort string
+	dstPort string
+}
+
+// conntrackFlow is one entry of the conntrack table
+type conntrackFlow struct {
+	family     string // ipv4 or ipv6
+	protocol   string
+	state      string // TCP conntrack state, otherwise UNREPLIED or REPLIED
+	original   conntrackTuple
+	reply      conntrackTuple
+	mark       uint32
+	zone       uint16
+	accounting bool // counters are only kept with net.netfilter.nf_conntrack_acct=1
+	origPkts   uint64
+	origBytes  uint64
+	replyPkts  uint64
+	replyBytes uint64
+}
+
+// nat derives the translation from the tuples: the reply goes to a different address or
+// port than the original came from after source NAT, and comes from a different one than
+// the original was sent to after destination NAT
+func (f conntrackFlow) nat() string {
+	snat := !f.reply.dst.Equal(f.original.src) || f.reply.dstPort != f.original.srcPort
+	dnat := !f.reply.src.Equal(f.original.dst) || f.reply.srcPort != f.original.dstPort
+	switch {
+	case snat && dnat:
+		return "snat+dnat"
+	case snat:
+		return "snat"
+	case dnat:
+		return "dnat"
+	}
+	return "none"
+}
+
+// readConntrack returns the conntrack table of the exporter's own namespace over ctnetlink.
+// When nf_conntrack_netlink is not available it falls back to /proc/net/nf_conntrack, and
+// keeps reading that file on later scrapes rather than failing over netlink every time.
+func (c *conntrackCollector) readConntrack() ([]conntrackFlow, error) {
+	file := procFilePath("net", "nf_conntrack")
+	if c.procFallback.Load() {
+		return readProcConntrack(file)
+	}
+	flows, err := dumpConntrack(netNamespace{})
+	if err == nil {
+		return flows, nil
+	}
+	if !c.procFallback.Swap(true) {
+		log.Printf("Warning: Could not dump conntrack table over netlink, reading %s from now on: %v", file, err)
+		countError("conntrack", "netlink")
+	}
+	return readProcConntrack(file)
+}
+
+// dumpConntrack dumps the conntrack entries of all address families with IPCTNL_MSG_CT_GET
+func dumpConntrack(ns netNamespace) ([]conntrackFlow, error) {
+	// struct nfgenmsg: AF_UNSPEC, NFNETLINK_V0, res_id 0
+	msgs, err := netlinkDump(ns, netlinkNetfilter, ctnetlinkGet, make([]byte, sizeofNfGenMsg))
+	if err != nil {
+		return nil, err
+	}
+	flows := make([]conntrackFlow, 0, len(msgs))
+	for _, m := range msgs {
+		if flow, ok := parseConntrackMsg(m.Data); ok {
+			flows = append(flows, flow)
+		}
+	}
+	return flows, nil
+}
+
+// parseConntrackMsg decodes one IPCTNL_MSG_CT_NEW dump message. ctnetlink attributes
+// are in network byte order.
+func parseConntrackMsg(b []byte) (conntrackFlow, bool) {
+	if len(b) < sizeofNfGenMsg {
+		return conntrackFlow{}, false
+	}
+	var flow conntrackFlow
+	switch b[0] {
+	case syscall.AF_INET:
+		flow.family = "ipv4"
+	case syscall.AF_INET6:
+		flow.family = "ipv6"
+	default:
+		return conntrackFlow{}, false
+	}
+
+	attrs := parseNetlinkAttrs(b[sizeofNfGenMsg:])
+	orig, ok := attrs[ctaTupleOrig]
+	if !ok {
+		return conntrackFlow{}, false
+	}
+	var protoNum uint8
+	flow.original, protoNum = parseConntrackTuple(orig)
+	flow.reply, _ = parseConntrackTuple(attrs[ctaTupleReply])
+	flow.protocol = conntrackProtocols[protoNum]
+	if flow.protocol == "" {
+		flow.protocol = strconv.Itoa(int(protoNum))
+	}
+
+	if v := attrs[ctaMark]; len(v) >= 4 {
+		flow.mark = binary.BigEndian.Uint32(v)
+	}
+	if v := attrs[ctaZone]; len(v) >= 2 {
+		flow.zone = binary.BigEndian.Uint16(v)
+	}
+
+	flow.state = "UNREPLIED"
+	if v := attrs[ctaStatus]; len(v) >= 4 && binary.BigEndian.Uint32(v)&ipsSeenReply != 0 {
+		flow.state = "REPLIED"
+	}
+	if tcp, ok := parseNetlinkAttrs(attrs[ctaProtoinfo])[ctaProtoinfoTCP]; ok {
+		if v := parseNetlinkAttrs(tcp)[ctaTCPState]; len(v) >= 1 && int(v[0]) < len(tcpConntrackStates) {
+			flow.state = tcpConntrackStates[v[0]]
+		}
+	}
+
+	if counters, ok := attrs[ctaCountersOrig]; ok {
+		flow.accounting = true
+		flow.origPkts, flow.origBytes = parseConntrackCounters(counters)
+		flow.replyPkts, flow.replyBytes = parseConntrackCounters(attrs[ctaCountersReply])
+	}
+	return flow, true
+}
+
+// parseConntrackTuple decodes a CTA_TUPLE_ORIG or CTA_TUPLE_REPLY attribute
+func parseConntrackTuple(b []byte) (conntrackTuple, uint8) {
+	var tuple conntrackTuple
+	ip := parseNetlinkAttrs(parseNetlinkAttrs(b)[ctaTupleIP])
+	for _, a := range []struct {
+		src, dst uint16
+	}{{ctaIPv4Src, ctaIPv4Dst}, {ctaIPv6Src, ctaIPv6Dst}} {
+		if src, ok := ip[a.src]; ok {
+			tuple.src = net.IP(append([]byte(nil), src...))
+			tuple.dst = net.IP(append([]byte(nil), ip[a.dst]...))
+		}
+	}
+
+	var protoNum uint8
+	proto := parseNetlinkAttrs(parseNetlinkAttrs(b)[ctaTupleProto])
+	if v := proto[ctaProtoNum]; len(v) >= 1 {
+		protoNum = v[0]
+	}
+	if v := proto[ctaProtoSrcPort]; len(v) >= 2 {
+		tuple.srcPort = strconv.Itoa(int(binary.BigEndian.Uint16(v)))
+	}
+	if v := proto[ctaProtoDstPort]; len(v) >= 2 {
+		tuple.dstPort = strconv.Itoa(int(binary.BigEndian.Uint16(v)))
+	}
+	return tuple, protoNum
+}
+
+// parseConntrackCounters decodes CTA_COUNTERS_ORIG or CTA_COUNTERS_REPLY
+func parseConntrackCounters(b []byte) (packets, bytes uint64) {
+	attrs := parseNetlinkAttrs(b)
+	if v := attrs[ctaPackets]; len(v) >= 8 {
+		packets = binary.BigEndian.Uint64(v)
+	}
+	if v := attrs[ctaBytes]; len(v) >= 8 {
+		bytes = binary.BigEndian.Uint64(v)
+	}
+	return packets, bytes
+}
+
+// readProcConntrack parses /proc/net/nf_conntrack, where every entry is a line like
+// "ipv4 2 tcp 6 431999 ESTABLISHED src=... dst=... sport=... dport=... [packets=... bytes=...]
+// src=... dst=... sport=... dport=... [ASSURED] mark=0 zone=0 use=2". The second src= starts
+// the reply tuple; packets/bytes only appear with accounting, zone only when non-zero.
+func readProcConntrack(file string) ([]conntrackFlow, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var flows []conntrackFlow
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		flow := conntrackFlow{family: fields[0], protocol: fields[2], state: "REPLIED"}
+		tuple := &flow.original
+		srcSeen := false
+		for _, field := range fields[5:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				// Only the TCP state is kept, as over ctnetlink; SCTP and DCCP entries also
+				// carry their protocol state but are told apart by [UNREPLIED] like the rest
+				switch {
+				case flow.protocol == "tcp":
+					if !strings.HasPrefix(field, "[") {
+						flow.state = field
+					}
+				case field == "[UNREPLIED]":
+					flow.state = "UNREPLIED"
+				}
+				continue
+			}
+			switch key {
+			case "src":
+				if srcSeen {
+					tuple = &flow.reply
+				}
+				srcSeen = true
+				tuple.src = net.ParseIP(value)
+			case "dst":
+				tuple.dst = net.ParseIP(value)
+			case "sport":
+				tuple.srcPort = value
+			case "dport":
+				tuple.dstPort = value
+			case "packets", "bytes":
+				n, err := strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("%s: invalid %s: %v", file, field, err)
+				}
+				flow.accounting = true
+				switch {
+				case key == "packets" && tuple == &flow.original:
+					flow.origPkts = n
+				case key == "bytes" && tuple == &flow.original:
+					flow.origBytes = n
+				case key == "packets":
+					flow.replyPkts = n
+				default:
+					flow.replyBytes = n
+				}
+			case "mark":
+				if n, err := strconv.ParseUint(value, 10, 32); err == nil {
+					flow.mark = uint32(n)
+				}
+			case "zone":
+				if n, err := strconv.ParseUint(value, 10, 16); err == nil {
+					flow.zone = uint16(n)
+				}
+			}
+		}
+		if flow.original.src == nil || flow.reply.src == nil {
+			continue
+		}
+		flows = append(flows, flow)
+	}
+	return flows, scanner.Err()
+}
+
+// conntrackFlowLabels identify one conntrack entry by both tuples
+var conntrackFlowLabels = []string{
+	"protocol", "ip_family", "state",
+	"original_source_address", "original_source_port", "original_destination_address", "original_destination_port",
+	"reply_source_address", "reply_source_port", "reply_destination_address", "reply_destination_port",
+	"nat", "mark", "zone",
+}
+
+// conntrackCollector exports the netfilter conntrack table, which unlike the socket
+// tables also holds the flows a NAT gateway or router forwards
+type conntrackCollector struct {
+	perFlow      bool
+	procFallback atomic.Bool // ctnetlink failed, the table is read from /proc
+	entries      *prometheus.Desc
+	entriesLimit *prometheus.Desc
+	flows        *prometheus.Desc
+	flowInfo     *prometheus.Desc
+	flowPackets  *prometheus.Desc
+	flowBytes    *prometheus.Desc
+}
+
+// newConntrackCollector exports one series per flow when perFlow is set, and always the
+// flow counts by protocol, state, NAT and zone
+func newConntrackCollector(perFlow bool) *conntrackCollector {
+	flowCounterLabels := append(append([]string{}, conntrackFlowLabels...), "tuple")
+	return &conntrackCollector{
+		perFlow: perFlow,
+		entries: prometheus.NewDesc(
+			"network_conntrack_entries",
+			"Number of entries in the conntrack table (nf_conntrack_count)",
+			nil, nil,
+		),
+		entriesLimit: prometheus.NewDesc(
+			"network_conntrack_entries_limit",
+			"Maximum number of entries in the conntrack table (nf_conntrack_max); new flows are dropped when it is reached",
+			nil, nil,
+		),
+		flows: prometheus.NewDesc(
+			"network_conntrack_flows",
+			"Number of conntrack entries by protocol, state, NAT translation and zone",
+			[]string{"protocol", "ip_family", "state", "nat", "zone"}, nil,
+		),
+		flowInfo: prometheus.NewDesc(
+			"network_conntrack_flow_info",
+			"Conntrack entries with these original and reply tuples, normally 1",
+			conntrackFlowLabels, nil,
+		),
+		flowPackets: prometheus.NewDesc(
+			"network_conntrack_flow_packets_total",
+			"Packets of a conntrack entry in the original or reply direction; requires nf_conntrack_acct",
+			flowCounterLabels, nil,
+		),
+		flowBytes: prometheus.NewDesc(
+			"network_conntrack_flow_bytes_total",
+			"Bytes of a conntrack entry in the original or reply direction; requires nf_conntrack_acct",
+			flowCounterLabels, nil,
+		),
+	}
+}
+
+func (c *conntrackCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.entries
+	ch <- c.entriesLimit
+	ch <- c.flows
+	ch <- c.flowInfo
+	ch <- c.flowPackets
+	ch <- c.flowBytes
+}
+
+func (c *conntrackCollector) Collect(ch chan<- prometheus.Metric) {
+	limit, err := readSysctlFloat("net", "netfilter", "nf_conntrack_max")
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: Could not read nf_conntrack_max: %v", err)
+			countError("conntrack", "read")
+		}
+		// nf_conntrack not loaded
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.entriesLimit, prometheus.GaugeValue, limit)
+	if count, err := readSysctlFloat("net", "netfilter", "nf_conntrack_count"); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, count)
+	}
+
+	start := time.Now()
+	flows, err := c.readConntrack()
+	observeDuration("conntrack", start)
+	if err != nil {
+		log.Printf("Error reading conntrack table: %v", err)
+		countError("conntrack", "read")
+		return
+	}
+
+	// Entries of protocols without ports (e.g. ICMP echo ids) can share all labels and are summed
+	counts, info, packets, bytes := newLabelCounter(), newLabelCounter(), newLabelCounter(), newLabelCounter()
+	for _, flow := range flows {
+		nat, zone := flow.nat(), strconv.Itoa(int(flow.zone))
+		counts.add(1, flow.protocol, flow.family, flow.state, nat, zone)
+		if !c.perFlow {
+			continue
+		}
+
+		labelValues := []string{
+			flow.protocol, flow.family, flow.state,
+			flow.original.src.String(), flow.original.srcPort, flow.original.dst.String(), flow.original.dstPort,
+			flow.reply.src.String(), flow.reply.srcPort, flow.reply.dst.String(), flow.reply.dstPort,
+			nat, strconv.FormatUint(uint64(flow.mark), 10), zone,
+		}
+		info.add(1, labelValues...)
+		if flow.accounting {
+			original := append(append([]string{}, labelValues...), "original")
+			reply := append(append([]string{}, labelValues...), "reply")
+			packets.add(float64(flow.origPkts), original...)
+			bytes.add(float64(flow.origBytes), original...)
+			packets.add(float64(flow.replyPkts), reply...)
+			bytes.add(float64(flow.replyBytes), reply...)
+		}
+	}
+	counts.collect(ch, c.flows)
+	info.collect(ch, c.flowInfo)
+	packets.collectCounters(ch, c.flowPackets)
+	bytes.collectCounters(ch, c.flowBytes)
+}
+
+// readSysctlFloat reads a numeric file below /proc/sys
+func readSysctlFloat(parts ...string) (float64, error) {
+	content, err := os.ReadFile(procFilePath(append([]string{"sys"}, parts...)...))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(content)), 64)
+}
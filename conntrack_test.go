@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// conntrackSummary is the comparable part of a conntrackFlow
+type conntrackSummary struct {
+	family, protocol, state string
+	original, reply         string
+	nat                     string
+	mark                    uint32
+	zone                    uint16
+	accounting              bool
+	counters                [4]uint64 // original packets and bytes, reply packets and bytes
+}
+
+func summarizeFlow(f conntrackFlow) conntrackSummary {
+	tuple := func(t conntrackTuple) string {
+		return net.JoinHostPort(t.src.String(), t.srcPort) + " -> " + net.JoinHostPort(t.dst.String(), t.dstPort)
+	}
+	return conntrackSummary{
+		family: f.family, protocol: f.protocol, state: f.state,
+		original: tuple(f.original), reply: tuple(f.reply),
+		nat: f.nat(), mark: f.mark, zone: f.zone, accounting: f.accounting,
+		counters: [4]uint64{f.origPkts, f.origBytes, f.replyPkts, f.replyBytes},
+	}
+}
+
+func TestReadProcConntrack(t *testing.T) {
+	flows, err := readProcConntrack("testdata/proc/net/nf_conntrack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []conntrackSummary{
+		{
+			family: "ipv4", protocol: "tcp", state: "ESTABLISHED",
+			original: "10.0.0.5:51234 -> 93.184.216.34:443", reply: "93.184.216.34:443 -> 203.0.113.1:51234",
+			nat: "snat", accounting: true, counters: [4]uint64{12, 1840, 10, 9620},
+		},
+		{
+			// TCP keeps its conntrack state even without a reply
+			family: "ipv4", protocol: "tcp", state: "SYN_SENT",
+			original: "192.168.1.10:40000 -> 192.168.1.20:22", reply: "192.168.1.20:22 -> 192.168.1.10:40000",
+			nat: "none", mark: 7,
+		},
+		{
+			family: "ipv4", protocol: "udp", state: "UNREPLIED",
+			original: "192.168.1.10:5353 -> 192.168.1.1:53", reply: "192.168.1.1:53 -> 192.168.1.10:5353",
+			nat: "none", zone: 3,
+		},
+		{
+			family: "ipv4", protocol: "udp", state: "REPLIED",
+			original: "203.0.113.9:6000 -> 203.0.113.1:8080", reply: "10.0.0.8:80 -> 203.0.113.9:6000",
+			nat: "dnat",
+		},
+		{
+			// The SCTP and DCCP protocol states are left out, as over ctnetlink
+			family: "ipv4", protocol: "sctp", state: "REPLIED",
+			original: "10.0.0.5:36412 -> 10.0.0.6:36412", reply: "10.0.0.6:36412 -> 10.0.0.5:36412",
+			nat: "none",
+		},
+		{
+			family: "ipv4", protocol: "dccp", state: "UNREPLIED",
+			original: "10.0.0.5:5001 -> 10.0.0.6:5002", reply: "10.0.0.6:5002 -> 10.0.0.5:5001",
+			nat: "none",
+		},
+		{
+			family: "ipv4", protocol: "icmp", state: "REPLIED",
+			original: "10.0.0.5: -> 10.0.0.1:", reply: "10.0.0.1: -> 10.0.0.5:",
+			nat: "none",
+		},
+		{
+			family: "ipv6", protocol: "tcp", state: "TIME_WAIT",
+			original: "[2001:db8::1]:48000 -> [2001:db8::2]:80", reply: "[2001:db8::2]:80 -> [2001:db8::1]:48000",
+			nat: "none",
+		},
+	}
+	if len(flows) != len(want) {
+		t.Fatalf("readProcConntrack() returned %d flows, want %d", len(flows), len(want))
+	}
+	for i, flow := range flows {
+		if got := summarizeFlow(flow); got != want[i] {
+			t.Errorf("flow %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+// nlattr encodes a netlink attribute with its padding
+func nlattr(attrType uint16, payload ...[]byte) []byte {
+	var data []byte
+	for _, p := range payload {
+		data = append(data, p...)
+	}
+	b := make([]byte, 4, 4+len(data)+3)
+	binary.NativeEndian.PutUint16(b[0:2], uint16(4+len(data)))
+	binary.NativeEndian.PutUint16(b[2:4], attrType)
+	b = append(b, data...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func be16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func be32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func be64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+// ctTuple encodes a CTA_TUPLE_ORIG or CTA_TUPLE_REPLY attribute
+func ctTuple(attrType uint16, src, dst string, proto uint8, sport, dport uint16) []byte {
+	srcIP, dstIP := net.ParseIP(src), net.ParseIP(dst)
+	ip := nlattr(ctaTupleIP|0x8000, nlattr(ctaIPv6Src, srcIP), nlattr(ctaIPv6Dst, dstIP))
+	if v4 := srcIP.To4(); v4 != nil {
+		ip = nlattr(ctaTupleIP|0x8000, nlattr(ctaIPv4Src, v4), nlattr(ctaIPv4Dst, dstIP.To4()))
+	}
+	return nlattr(attrType|0x8000, ip, nlattr(ctaTupleProto|0x8000,
+		nlattr(ctaProtoNum, []byte{proto}), nlattr(ctaProtoSrcPort, be16(sport)), nlattr(ctaProtoDstPort, be16(dport))))
+}
+
+// ctMsg encodes an IPCTNL_MSG_CT_NEW message body: struct nfgenmsg followed by the attributes
+func ctMsg(family uint8, attrs ...[]byte) []byte {
+	b := []byte{family, 0, 0, 0}
+	for _, a := range attrs {
+		b = append(b, a...)
+	}
+	return b
+}
+
+func TestParseConntrackMsg(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		want conntrackSummary
+		ok   bool
+	}{
+		{
+			name: "tcp with accounting and dnat",
+			msg: ctMsg(syscall.AF_INET,
+				ctTuple(ctaTupleOrig, "198.51.100.7", "203.0.113.1", 6, 40000, 80),
+				ctTuple(ctaTupleReply, "10.0.0.8", "198.51.100.7", 6, 8080, 40000),
+				nlattr(ctaStatus, be32(ipsSeenReply|1<<2)),
+				nlattr(ctaProtoinfo|0x8000, nlattr(ctaProtoinfoTCP|0x8000, nlattr(ctaTCPState, []byte{3}))),
+				nlattr(ctaMark, be32(42)),
+				nlattr(ctaZone, be16(2)),
+				nlattr(ctaCountersOrig|0x8000, nlattr(ctaPackets, be64(5)), nlattr(ctaBytes, be64(400))),
+				nlattr(ctaCountersReply|0x8000, nlattr(ctaPackets, be64(4)), nlattr(ctaBytes, be64(3000))),
+			),
+			want: conntrackSummary{
+				family: "ipv4", protocol: "tcp", state: "ESTABLISHED",
+				original: "198.51.100.7:40000 -> 203.0.113.1:80", reply: "10.0.0.8:8080 -> 198.51.100.7:40000",
+				nat: "dnat", mark: 42, zone: 2, accounting: true, counters: [4]uint64{5, 400, 4, 3000},
+			},
+			ok: true,
+		},
+		{
+			name: "udp without reply",
+			msg: ctMsg(syscall.AF_INET6,
+				ctTuple(ctaTupleOrig, "2001:db8::1", "2001:db8::53", 17, 5353, 53),
+				ctTuple(ctaTupleReply, "2001:db8::53", "2001:db8::1", 17, 53, 5353),
+				nlattr(ctaStatus, be32(1<<3)),
+			),
+			want: conntrackSummary{
+				family: "ipv6", protocol: "udp", state: "UNREPLIED",
+				original: "[2001:db8::1]:5353 -> [2001:db8::53]:53", reply: "[2001:db8::53]:53 -> [2001:db8::1]:5353",
+				nat: "none",
+			},
+			ok: true,
+		},
+		{
+			// CTA_PROTOINFO_SCTP is not decoded, matching the /proc parser
+			name: "sctp state is not decoded",
+			msg: ctMsg(syscall.AF_INET,
+				ctTuple(ctaTupleOrig, "10.0.0.5", "10.0.0.6", 132, 36412, 36412),
+				ctTuple(ctaTupleReply, "10.0.0.6", "10.0.0.5", 132, 36412, 36412),
+				nlattr(ctaStatus, be32(ipsSeenReply)),
+				nlattr(ctaProtoinfo|0x8000, nlattr(3|0x8000, nlattr(1, []byte{3}))),
+			),
+			want: conntrackSummary{
+				family: "ipv4", protocol: "sctp", state: "REPLIED",
+				original: "10.0.0.5:36412 -> 10.0.0.6:36412", reply: "10.0.0.6:36412 -> 10.0.0.5:36412",
+				nat: "none",
+			},
+			ok: true,
+		},
+		{
+			name: "unknown protocol number",
+			msg: ctMsg(syscall.AF_INET,
+				ctTuple(ctaTupleOrig, "10.0.0.5", "10.0.0.6", 99, 0, 0),
+				ctTuple(ctaTupleReply, "10.0.0.6", "10.0.0.5", 99, 0, 0),
+			),
+			want: conntrackSummary{
+				family: "ipv4", protocol: "99", state: "UNREPLIED",
+				original: "10.0.0.5:0 -> 10.0.0.6:0", reply: "10.0.0.6:0 -> 10.0.0.5:0",
+				nat: "none",
+			},
+			ok: true,
+		},
+		{
+			name: "unsupported family",
+			msg:  ctMsg(syscall.AF_UNIX, ctTuple(ctaTupleOrig, "10.0.0.5", "10.0.0.6", 6, 1, 2)),
+		},
+		{
+			name: "missing original tuple",
+			msg:  ctMsg(syscall.AF_INET, nlattr(ctaStatus, be32(ipsSeenReply))),
+		},
+		{
+			name: "truncated",
+			msg:  []byte{syscall.AF_INET, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow, ok := parseConntrackMsg(tt.msg)
+			if ok != tt.ok {
+				t.Fatalf("parseConntrackMsg() ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if got := summarizeFlow(flow); got != tt.want {
+				t.Errorf("parseConntrackMsg() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
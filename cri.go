@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// containerIDPattern matches a cgroup path component holding a container ID:
+//
+//	docker (cgroupfs):     /docker/<id>
+//	docker (systemd):      /system.slice/docker-<id>.scope
+//	containerd (k8s):      /kubepods.slice/.../cri-containerd-<id>.scope or /kubepods/burstable/pod<uid>/<id>
+//	cri-o:                 /kubepods.slice/.../crio-<id>.scope
+//	podman:                /machine.slice/libpod-<id>.scope
+var containerIDPattern = regexp.MustCompile(`^(?:docker-|cri-containerd-|crio-|libpod-)?([0-9a-f]{64})(?:\.scope)?$`)
+
+// containerIDFromCgroup extracts the container ID from a cgroup path, or returns ""
+// for processes that are not in a container. Runtime monitor processes (conmon)
+// share the ID in their scope name but are not the container itself.
+func containerIDFromCgroup(cgroup string) string {
+	parts := strings.Split(cgroup, "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if m := containerIDPattern.FindStringSubmatch(parts[i]); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// podInfo is the Kubernetes pod a container belongs to
+type podInfo struct {
+	name      string
+	namespace string
+}
+
+// criClient resolves container IDs to pods with the CRI RuntimeService.ContainerStatus
+// gRPC call on the container runtime socket (containerd, cri-o). Lookups run in the
+// background and are cached, since a container never moves between pods.
+type criClient struct {
+	endpoint   string
+	client     *http.Client
+	retryAfter time.Duration // how long a failed lookup is cached before it is retried
+	slots      chan struct{} // bounds concurrent lookups
+	lookups    sync.WaitGroup
+
+	mu      sync.Mutex
+	cache   map[string]criCacheEntry
+	pending map[string]bool
+}
+
+// criCacheEntry is a cached lookup. Failed lookups expire, so a container gets its pod
+// once a restarting or overloaded runtime answers again.
+type criCacheEntry struct {
+	pod     podInfo
+	expires time.Time // zero for successful lookups
+}
+
+const (
+	// criCacheLimit bounds the pod cache; it is simply reset when full, which only costs
+	// one extra lookup per live container
+	criCacheLimit = 4096
+	// criRetryInterval is how long a failed lookup is cached
+	criRetryInterval = 30 * time.Second
+	// criMaxLookups is the number of ContainerStatus calls in flight at once
+	criMaxLookups = 4
+)
+
+// newCRIClient creates a client for a runtime endpoint such as unix:///run/containerd/containerd.sock
+func newCRIClient(endpoint string) *criClient {
+	socketPath := strings.TrimPrefix(endpoint, "unix://")
+	return &criClient{
+		endpoint: endpoint,
+		client: &http.Client{
+			Timeout: 2 * time.Second,
+			// gRPC needs HTTP/2; the runtime socket speaks it in cleartext (h2c)
+			Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+		retryAfter: criRetryInterval,
+		slots:      make(chan struct{}, criMaxLookups),
+		cache:      make(map[string]criCacheEntry),
+		pending:    make(map[string]bool),
+	}
+}
+
+// podForContainer returns the cached pod of a container. Containers without a current
+// cache entry are looked up in the background and return an empty podInfo until the
+// lookup succeeds, so a slow or unreachable runtime never delays a scrape.
+func (c *criClient) podForContainer(containerID string) podInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.cache[containerID]
+	if ok && (entry.expires.IsZero() || time.Now().Before(entry.expires)) {
+		return entry.pod
+	}
+	if !c.pending[containerID] {
+		c.pending[containerID] = true
+		c.lookups.Add(1)
+		go c.lookup(containerID)
+	}
+	return podInfo{}
+}
+
+// lookup resolves one container with the runtime and caches the result
+func (c *criClient) lookup(containerID string) {
+	defer c.lookups.Done()
+
+	c.slots <- struct{}{}
+	start := time.Now()
+	pod, err := c.containerStatus(containerID)
+	observeDuration("cri", start)
+	<-c.slots
+
+	entry := criCacheEntry{pod: pod}
+	if err != nil {
+		log.Printf("Warning: CRI lookup for container %s via %s failed, retrying in %s: %v", containerID, c.endpoint, c.retryAfter, err)
+		countError("cri", "lookup")
+		entry.expires = time.Now().Add(c.retryAfter)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, containerID)
+	if len(c.cache) >= criCacheLimit {
+		c.cache = make(map[string]criCacheEntry)
+	}
+	c.cache[containerID] = entry
+}
+
+// containerStatus issues a unary ContainerStatus call and reads the pod labels the
+// kubelet sets on every container
+func (c *criClient) containerStatus(containerID string) (podInfo, error) {
+	// ContainerStatusRequest { string container_id = 1; }
+	msg := protowire.AppendTag(nil, 1, protowire.BytesType)
+	msg = protowire.AppendString(msg, containerID)
+
+	// gRPC length-prefixed message: compressed flag + big-endian length
+	body := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(body[1:5], uint32(len(msg)))
+	body = append(body, msg...)
+
+	req, err := http.NewRequest(http.MethodPost, "http://localhost/runtime.v1.RuntimeService/ContainerStatus", bytes.NewReader(body))
+	if err != nil {
+		return podInfo{}, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return podInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return podInfo{}, err
+	}
+
+	// Errors come back as grpc-status in the trailers, or in the headers for trailers-only responses
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if status != "0" {
+		return podInfo{}, fmt.Errorf("grpc status %s: %s", status, resp.Trailer.Get("Grpc-Message"))
+	}
+	if len(data) < 5 {
+		return podInfo{}, fmt.Errorf("short gRPC response: %d bytes", len(data))
+	}
+
+	// ContainerStatusResponse { ContainerStatus status = 1; }
+	statusMsg := protoField(data[5:], 1)
+	// ContainerStatus { map<string, string> labels = 12; }
+	labels := protoStringMap(statusMsg, 12)
+
+	return podInfo{
+		name:      labels["io.kubernetes.pod.name"],
+		namespace: labels["io.kubernetes.pod.namespace"],
+	}, nil
+}
+
+// protoField returns the last length-delimited field with the given number
+func protoField(b []byte, field protowire.Number) []byte {
+	var value []byte
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return value
+		}
+		b = b[n:]
+		if typ == protowire.BytesType && num == field {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return value
+			}
+			value = v
+			b = b[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return value
+		}
+		b = b[n:]
+	}
+	return value
+}
+
+// protoStringMap decodes a map<string, string> field, encoded as repeated entries of { key = 1; value = 2; }
+func protoStringMap(b []byte, field protowire.Number) map[string]string {
+	result := make(map[string]string)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			break
+		}
+		b = b[n:]
+		if typ == protowire.BytesType && num == field {
+			entry, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				break
+			}
+			b = b[n:]
+			result[string(protoField(entry, 1))] = string(protoField(entry, 2))
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			break
+		}
+		b = b[n:]
+	}
+	return result
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const testContainerID = "3f4e9c2a1b0d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f"
+
+func TestContainerIDFromCgroup(t *testing.T) {
+	tests := []struct {
+		name   string
+		cgroup string
+		want   string
+	}{
+		{"docker cgroupfs", "/docker/" + testContainerID, testContainerID},
+		{"docker systemd", "/system.slice/docker-" + testContainerID + ".scope", testContainerID},
+		{"containerd systemd", "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1234.slice/cri-containerd-" + testContainerID + ".scope", testContainerID},
+		{"containerd cgroupfs", "/kubepods/burstable/pod1234/" + testContainerID, testContainerID},
+		{"cri-o", "/kubepods.slice/kubepods-pod1234.slice/crio-" + testContainerID + ".scope", testContainerID},
+		{"podman", "/machine.slice/libpod-" + testContainerID + ".scope", testContainerID},
+		{"conmon", "/machine.slice/libpod-conmon-" + testContainerID + ".scope", ""},
+		{"systemd service", "/system.slice/nginx.service", ""},
+		{"root", "/", ""},
+		{"short id", "/docker/3f4e9c2a1b0d", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := containerIDFromCgroup(tt.cgroup); got != tt.want {
+				t.Errorf("containerIDFromCgroup(%q) = %q, want %q", tt.cgroup, got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeRuntime is a CRI runtime serving ContainerStatus over h2c on a unix socket
+type fakeRuntime struct {
+	mu    sync.Mutex
+	calls int
+	pods  map[string]podInfo // by container ID
+}
+
+func (f *fakeRuntime) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.calls++
+	pods := f.pods
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	if r.URL.Path != "/runtime.v1.RuntimeService/ContainerStatus" {
+		w.Header().Set("Grpc-Status", "12") // UNIMPLEMENTED
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil || len(body) < 5 {
+		w.Header().Set("Grpc-Status", "3") // INVALID_ARGUMENT
+		return
+	}
+	pod, ok := pods[string(protoField(body[5:], 1))]
+	if !ok {
+		w.Header().Set("Grpc-Status", "5") // NOT_FOUND
+		w.Header().Set("Grpc-Message", "container not found")
+		return
+	}
+
+	// ContainerStatusResponse { ContainerStatus status = 1 { map<string, string> labels = 12; } }
+	var status []byte
+	for k, v := range map[string]string{
+		"io.kubernetes.pod.name":       pod.name,
+		"io.kubernetes.pod.namespace":  pod.namespace,
+		"io.kubernetes.container.name": "app",
+	} {
+		entry := protowire.AppendTag(nil, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, k)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, v)
+		status = protowire.AppendTag(status, 12, protowire.BytesType)
+		status = protowire.AppendBytes(status, entry)
+	}
+	msg := protowire.AppendTag(nil, 1, protowire.BytesType)
+	msg = protowire.AppendBytes(msg, status)
+
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(msg)))
+	w.Write(append(frame, msg...))
+	w.Header().Set("Grpc-Status", "0")
+}
+
+func (f *fakeRuntime) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func startFakeRuntime(t *testing.T, runtime *fakeRuntime) string {
+	t.Helper()
+	socketPath := filepath.Join(t.TempDir(), "cri.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: h2c.NewHandler(runtime, &http2.Server{})}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return "unix://" + socketPath
+}
+
+func TestCRIClientPodForContainer(t *testing.T) {
+	runtime := &fakeRuntime{pods: map[string]podInfo{
+		testContainerID: {name: "web-7d4b9c", namespace: "shop"},
+	}}
+	client := newCRIClient(startFakeRuntime(t, runtime))
+
+	// The first scrape only starts the lookup
+	if got := client.podForContainer(testContainerID); got != (podInfo{}) {
+		t.Errorf("podForContainer() before the lookup = %+v, want empty", got)
+	}
+	client.lookups.Wait()
+
+	want := podInfo{name: "web-7d4b9c", namespace: "shop"}
+	if got := client.podForContainer(testContainerID); got != want {
+		t.Errorf("podForContainer() = %+v, want %+v", got, want)
+	}
+	// Containers never move between pods, so later scrapes are served from the cache
+	if got := client.podForContainer(testContainerID); got != want {
+		t.Errorf("cached podForContainer() = %+v, want %+v", got, want)
+	}
+	client.lookups.Wait()
+	if calls := runtime.callCount(); calls != 1 {
+		t.Errorf("runtime called %d times, want 1", calls)
+	}
+}
+
+func TestCRIClientContainerNotFound(t *testing.T) {
+	runtime := &fakeRuntime{}
+	client := newCRIClient(startFakeRuntime(t, runtime))
+
+	unknown := strings.Repeat("a", 64)
+	_, err := client.containerStatus(unknown)
+	if err == nil || !strings.Contains(err.Error(), "grpc status 5") {
+		t.Fatalf("containerStatus() error = %v, want grpc status 5", err)
+	}
+
+	client.podForContainer(unknown)
+	client.lookups.Wait()
+	// Within the retry interval a failure is not asked again
+	if got := client.podForContainer(unknown); got != (podInfo{}) {
+		t.Errorf("podForContainer() = %+v, want empty", got)
+	}
+	client.lookups.Wait()
+	if calls := runtime.callCount(); calls != 2 {
+		t.Errorf("runtime called %d times, want 2", calls)
+	}
+
+	// Once it has passed, the container is looked up again and resolves when the runtime knows it
+	runtime.mu.Lock()
+	runtime.pods = map[string]podInfo{unknown: {name: "late-pod", namespace: "shop"}}
+	runtime.mu.Unlock()
+	client.mu.Lock()
+	client.cache[unknown] = criCacheEntry{expires: time.Now().Add(-time.Second)}
+	client.mu.Unlock()
+	client.podForContainer(unknown)
+	client.lookups.Wait()
+
+	want := podInfo{name: "late-pod", namespace: "shop"}
+	if got := client.podForContainer(unknown); got != want {
+		t.Errorf("podForContainer() after retry = %+v, want %+v", got, want)
+	}
+	if calls := runtime.callCount(); calls != 3 {
+		t.Errorf("runtime called %d times, want 3", calls)
+	}
+}
+
+func TestCRIClientUnreachable(t *testing.T) {
+	client := newCRIClient("unix://" + filepath.Join(t.TempDir(), "missing.sock"))
+	client.podForContainer(testContainerID)
+	client.lookups.Wait()
+	if got := client.podForContainer(testContainerID); got != (podInfo{}) {
+		t.Errorf("podForContainer() = %+v, want empty", got)
+	}
+}
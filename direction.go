@@ -0,0 +1,67 @@
+package main
+
+import "net"
+
+// listenerKey identifies a listening socket within its network namespace
+type listenerKey struct {
+	protocol string
+	address  string
+	port     string
+	netns    string
+}
+
+// listenerIndex holds the listening sockets of a namespace: TCP sockets in LISTEN and
+// UDP sockets that are bound but not connected, i.e. accept datagrams from any peer
+type listenerIndex map[listenerKey]struct{}
+
+// isListener reports whether a socket accepts connections or datagrams from any peer
+func isListener(conn tcpConnection, protocol string) bool {
+	if protocol == "udp" {
+		return conn.sourcePort != "0" && conn.destinationPort == "0"
+	}
+	return conn.state == "LISTEN"
+}
+
+// add indexes the listening sockets among connections
+func (l listenerIndex) add(connections []tcpConnection, protocol string) {
+	for _, conn := range connections {
+		if isListener(conn, protocol) {
+			l[listenerKey{protocol, conn.sourceAddress, conn.sourcePort, conn.netns}] = struct{}{}
+		}
+	}
+}
+
+// serves reports whether a listener accepts traffic on the socket's local address and port.
+// Listeners on 0.0.0.0 serve every IPv4 address, listeners on :: every address, since
+// dual-stack sockets also accept IPv4 (reported in dotted form).
+func (l listenerIndex) serves(conn tcpConnection, protocol string) bool {
+	for _, address := range []string{conn.sourceAddress, "0.0.0.0", "::"} {
+		if address == "0.0.0.0" && conn.ipFamily != "ipv4" {
+			continue
+		}
+		if _, ok := l[listenerKey{protocol, address, conn.sourcePort, conn.netns}]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// connectionDirection classifies a socket as "local" (loopback to loopback), "incoming"
+// (a listener, or accepted by one), "outgoing", or "unknown" for UDP sockets that are not
+// bound yet. It records in conn.accepted whether the local end is the accepting one, which
+// "local" connections have on one of their two sockets.
+func connectionDirection(conn *tcpConnection, protocol string, listeners listenerIndex) string {
+	if protocol == "udp" && conn.sourcePort == "0" {
+		return "unknown"
+	}
+	conn.accepted = listeners.serves(*conn, protocol)
+
+	src, dst := net.ParseIP(conn.sourceAddress), net.ParseIP(conn.destinationAddress)
+	if src != nil && dst != nil && src.IsLoopback() && dst.IsLoopback() {
+		return "local"
+	}
+	if conn.accepted {
+		return "incoming"
+	}
+	return "outgoing"
+}
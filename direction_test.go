@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+// sock builds a socket in netns 1; ipFamily is derived from the local address like the parsers do
+func sock(src, sport, dst, dport, state string) tcpConnection {
+	return tcpConnection{
+		sourceAddress: src, sourcePort: sport, destinationAddress: dst, destinationPort: dport,
+		state: state, ipFamily: ipFamily(src), netns: "1",
+	}
+}
+
+func TestConnectionDirection(t *testing.T) {
+	listeners := make(listenerIndex)
+	listeners.add([]tcpConnection{
+		sock("10.0.0.1", "443", "0.0.0.0", "0", "LISTEN"),
+		sock("0.0.0.0", "22", "0.0.0.0", "0", "LISTEN"),
+		sock("::", "8080", "::", "0", "LISTEN"),
+		sock("127.0.0.1", "5432", "0.0.0.0", "0", "LISTEN"),
+		// Established sockets are not listeners
+		sock("10.0.0.1", "40000", "93.184.216.34", "443", "ESTABLISHED"),
+	}, "tcp")
+	listeners.add([]tcpConnection{
+		sock("0.0.0.0", "53", "0.0.0.0", "0", "LISTEN"),
+		sock("10.0.0.1", "443", "0.0.0.0", "0", "LISTEN"),
+		// Connected UDP sockets only receive from their peer
+		sock("10.0.0.1", "50000", "8.8.8.8", "53", "LISTEN"),
+	}, "udp")
+
+	otherNetns := sock("10.0.0.1", "443", "203.0.113.9", "51000", "ESTABLISHED")
+	otherNetns.netns = "2"
+
+	tests := []struct {
+		name      string
+		protocol  string
+		conn      tcpConnection
+		direction string
+		accepted  bool
+	}{
+		{"tcp listener", "tcp", sock("10.0.0.1", "443", "0.0.0.0", "0", "LISTEN"), "incoming", true},
+		{"tcp accepted on specific bind", "tcp", sock("10.0.0.1", "443", "203.0.113.9", "51000", "ESTABLISHED"), "incoming", true},
+		{"tcp same port on another address", "tcp", sock("10.0.0.2", "443", "203.0.113.9", "51000", "ESTABLISHED"), "outgoing", false},
+		{"tcp accepted on wildcard bind", "tcp", sock("10.0.0.2", "22", "203.0.113.9", "51000", "ESTABLISHED"), "incoming", true},
+		{"tcp ipv4 wildcard does not serve ipv6", "tcp", sock("2001:db8::1", "22", "2001:db8::9", "51000", "ESTABLISHED"), "outgoing", false},
+		// Dual-stack listeners report IPv4 peers as mapped addresses, parsed to dotted form
+		{"tcp v4-mapped on dual-stack listener", "tcp", sock("10.0.0.1", "8080", "203.0.113.9", "51000", "ESTABLISHED"), "incoming", true},
+		{"tcp ipv6 on dual-stack listener", "tcp", sock("2001:db8::1", "8080", "2001:db8::9", "51000", "ESTABLISHED"), "incoming", true},
+		{"tcp outgoing to a listened port", "tcp", sock("10.0.0.1", "40000", "93.184.216.34", "443", "ESTABLISHED"), "outgoing", false},
+		{"tcp listener in another netns", "tcp", otherNetns, "outgoing", false},
+		{"tcp loopback client", "tcp", sock("127.0.0.1", "40001", "127.0.0.1", "5432", "ESTABLISHED"), "local", false},
+		{"tcp loopback server", "tcp", sock("127.0.0.1", "5432", "127.0.0.1", "40001", "ESTABLISHED"), "local", true},
+		{"tcp ipv6 loopback", "tcp", sock("::1", "40002", "::1", "9000", "ESTABLISHED"), "local", false},
+		{"tcp loopback to another address", "tcp", sock("127.0.0.1", "40003", "10.0.0.1", "443", "ESTABLISHED"), "outgoing", false},
+		{"udp unbound", "udp", sock("0.0.0.0", "0", "0.0.0.0", "0", "UNCONN"), "unknown", false},
+		{"udp bound listener", "udp", sock("0.0.0.0", "53", "0.0.0.0", "0", "LISTEN"), "incoming", true},
+		{"udp connected client", "udp", sock("10.0.0.1", "50000", "8.8.8.8", "53", "LISTEN"), "outgoing", false},
+		// e.g. a QUIC server connecting a socket per client on its listening port
+		{"udp connected on listener port", "udp", sock("10.0.0.1", "443", "203.0.113.9", "60000", "LISTEN"), "incoming", true},
+		{"udp port served by tcp only", "udp", sock("10.0.0.2", "22", "203.0.113.9", "60000", "LISTEN"), "outgoing", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := tt.conn
+			if got := connectionDirection(&conn, tt.protocol, listeners); got != tt.direction || conn.accepted != tt.accepted {
+				t.Errorf("connectionDirection() = %s, accepted %v, want %s, accepted %v", got, conn.accepted, tt.direction, tt.accepted)
+			}
+		})
+	}
+}
@@ -2,7 +2,12 @@ module conn-exporter
 
 go 1.21
 
-require github.com/prometheus/client_golang v1.19.1
+require (
+	github.com/prometheus/client_golang v1.19.1
+	golang.org/x/net v0.21.0
+	golang.org/x/sys v0.17.0
+	google.golang.org/protobuf v1.33.0
+)
 
 require (
 	github.com/beorn7/perks v1.0.1 // indirect
@@ -10,6 +15,5 @@ require (
 	github.com/prometheus/client_model v0.5.0 // indirect
 	github.com/prometheus/common v0.48.0 // indirect
 	github.com/prometheus/procfs v0.12.0 // indirect
-	golang.org/x/sys v0.17.0 // indirect
-	google.golang.org/protobuf v1.33.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
 )
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// interfaceInfo is the metadata of one interface, joined with its master device
+type interfaceInfo struct {
+	linkMsg
+	masterName string
+	masterKind string
+	addrs      []addrMsg // only known when the registry is loaded over netlink
+}
+
+// sysfsInterfaceDetails reads interface metadata from /sys/class/net when netlink is not
+// available. Link types come from DEVTYPE in the uevent file and VLAN IDs from
+// /proc/net/vlan; address labels and flags cannot be read this way.
+func sysfsInterfaceDetails() ([]interfaceInfo, error) {
+	entries, err := os.ReadDir(sysFilePath("class", "net"))
+	if err != nil {
+		return nil, err
+	}
+
+	kinds := make(map[string]string)
+	var infos []interfaceInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		info := interfaceInfo{linkMsg: linkMsg{name: name, kind: sysfsDevType(name)}}
+		kinds[name] = info.kind
+		if ignoredInterface(name) {
+			continue
+		}
+		if mtu, err := readSysfsUint(name, "mtu"); err == nil {
+			info.mtu = uint32(mtu)
+		}
+		// "master" is a symlink to the bond, bridge or VRF device
+		if target, err := os.Readlink(sysFilePath("class", "net", name, "master")); err == nil {
+			info.masterName = filepath.Base(target)
+		}
+		if info.kind == "vlan" {
+			info.vlanID = procVlanID(name)
+		}
+		infos = append(infos, info)
+	}
+	for i := range infos {
+		infos[i].masterKind = kinds[infos[i].masterName]
+	}
+	return infos, nil
+}
+
+// sysfsDevType returns DEVTYPE from an interface's uevent file, "" for plain devices
+func sysfsDevType(name string) string {
+	content, err := os.ReadFile(sysFilePath("class", "net", name, "uevent"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if value, ok := strings.CutPrefix(line, "DEVTYPE="); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// procVlanID reads the VLAN ID from /proc/net/vlan/<name> ("eth0.100  VID: 100 ...")
+func procVlanID(name string) int {
+	content, err := os.ReadFile(procFilePath("net", "vlan", name))
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(content))
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "VID:" {
+			id, _ := strconv.Atoi(fields[i+1])
+			return id
+		}
+	}
+	return 0
+}
+
+// readSysfsUint reads an unsigned integer attribute of an interface
+func readSysfsUint(name, attr string) (uint64, error) {
+	content, err := os.ReadFile(sysFilePath("class", "net", name, attr))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+}
+
+// interfaceCollector exports interface metadata joinable on the interface label of the
+// connection metrics: the bond, bridge or VRF an interface belongs to, its VLAN ID, MTU
+// and speed, and the labels and primary/secondary status of its addresses
+type interfaceCollector struct {
+	info        *prometheus.Desc
+	mtu         *prometheus.Desc
+	speed       *prometheus.Desc
+	addressInfo *prometheus.Desc
+}
+
+func newInterfaceCollector() *interfaceCollector {
+	return &interfaceCollector{
+		info: prometheus.NewDesc(
+			"network_interface_info",
+			"Interface link type, the master device it is enslaved to (bond, bridge or VRF) and its VLAN ID",
+			[]string{"interface", "kind", "master", "master_kind", "vlan_id"},
+			nil,
+		),
+		mtu: prometheus.NewDesc(
+			"network_interface_mtu_bytes",
+			"Interface MTU",
+			[]string{"interface"},
+			nil,
+		),
+		speed: prometheus.NewDesc(
+			"network_interface_speed_bytes",
+			"Negotiated interface link speed in bytes per second",
+			[]string{"interface"},
+			nil,
+		),
+		addressInfo: prometheus.NewDesc(
+			"network_interface_address_info",
+			"Address configured on an interface, its label (e.g. eth0:1) and whether the kernel flags it as secondary",
+			[]string{"interface", "address", "label", "secondary"},
+			nil,
+		),
+	}
+}
+
+func (c *interfaceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.info
+	ch <- c.mtu
+	ch <- c.speed
+	ch <- c.addressInfo
+}
+
+func (c *interfaceCollector) Collect(ch chan<- prometheus.Metric) {
+	infos := localInterfaces.interfaceDetails()
+	if infos == nil {
+		var err error
+		infos, err = sysfsInterfaceDetails()
+		if err != nil {
+			log.Printf("Warning: Could not read interface details: %v", err)
+			countError("interfaces", "details")
+			return
+		}
+	}
+
+	for _, info := range infos {
+		vlanID := ""
+		if info.kind == "vlan" {
+			vlanID = strconv.Itoa(info.vlanID)
+		}
+		ch <- prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1, info.name, info.kind, info.masterName, info.masterKind, vlanID)
+		if info.mtu > 0 {
+			ch <- prometheus.MustNewConstMetric(c.mtu, prometheus.GaugeValue, float64(info.mtu), info.name)
+		}
+		// Reads fail or return -1 while the link is down or the driver does not report a speed
+		if mbps, err := readSysfsUint(info.name, "speed"); err == nil && mbps > 0 {
+			ch <- prometheus.MustNewConstMetric(c.speed, prometheus.GaugeValue, float64(mbps)*1000*1000/8, info.name)
+		}
+		for _, addr := range info.addrs {
+			ch <- prometheus.MustNewConstMetric(c.addressInfo, prometheus.GaugeValue, 1, info.name, addr.ip.String(), addr.label, strconv.FormatBool(addr.secondary))
+		}
+	}
+}
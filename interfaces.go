@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// interfaceRefreshInterval limits full rescans on cache misses when interface changes
+// cannot be monitored
+const interfaceRefreshInterval = 30 * time.Second
+
+// linkState is what the registry knows about one interface
+type linkState struct {
+	linkMsg
+	addrs map[string]addrMsg
+}
+
+// interfaceRegistry maps local addresses to the interfaces they are configured on. It is
+// loaded from an rtnetlink dump and then updated incrementally from link and address
+// notifications, so lookups never rescan the system. Without netlink it falls back to
+// discoverInterfacesSysfs and rescans at most every interfaceRefreshInterval.
+type interfaceRegistry struct {
+	mu        sync.RWMutex
+	links     map[int32]*linkState
+	byIP      map[string]string
+	loaded    time.Time
+	monitored bool
+}
+
+// localInterfaces is the interface registry of the exporter's own network namespace
+var localInterfaces = &interfaceRegistry{}
+
+// ignoredInterface reports whether addresses on an interface are left out of the registry:
+// container plumbing that would otherwise claim addresses of other namespaces
+func ignoredInterface(name string) bool {
+	return strings.Contains(name, "docker") ||
+		(strings.Contains(name, "veth") && !strings.Contains(name, "vnet")) ||
+		(strings.Contains(name, "br-") && !strings.Contains(name, "virbr"))
+}
+
+// lookup returns the interface an address is configured on
+func (r *interfaceRegistry) lookup(ip string) (string, bool) {
+	r.mu.RLock()
+	stale := r.stale()
+	iface, ok := r.byIP[ip]
+	r.mu.RUnlock()
+
+	if ok || !stale {
+		return iface, ok
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Another lookup may have reloaded in the meantime
+	if r.stale() {
+		r.load()
+	}
+	iface, ok = r.byIP[ip]
+	return iface, ok
+}
+
+// stale reports whether the registry needs a full reload; callers hold r.mu
+func (r *interfaceRegistry) stale() bool {
+	return r.byIP == nil || (!r.monitored && time.Since(r.loaded) > interfaceRefreshInterval)
+}
+
+// load rebuilds the registry from scratch; callers hold r.mu
+func (r *interfaceRegistry) load() {
+	interfaceCacheRefreshes.Inc()
+	r.loaded = time.Now()
+
+	err := r.loadNetlink()
+	if err == nil {
+		setInterfaceDiscoveryMethod("netlink")
+		return
+	}
+	log.Printf("Warning: Could not dump interfaces over netlink, reading %s and %s instead: %v", sysfsRoot, procfsRoot, err)
+	countError("interfaces", "netlink")
+
+	// Notifications can only be applied to netlink link state, so rescan periodically instead
+	r.monitored = false
+	r.links = nil
+	byIP, err := discoverInterfacesSysfs()
+	if err != nil {
+		log.Printf("Error getting network interfaces: %v", err)
+		countError("interfaces", "discover")
+		setInterfaceDiscoveryMethod("none")
+		if r.byIP == nil {
+			r.byIP = make(map[string]string)
+		}
+		return
+	}
+	setInterfaceDiscoveryMethod("sysfs")
+	r.byIP = byIP
+	logAddressMap(r.byIP)
+}
+
+// loadNetlink reads all links and addresses with RTM_GETLINK and RTM_GETADDR dumps
+func (r *interfaceRegistry) loadNetlink() error {
+	own := netNamespace{}
+	links, err := dumpLinks(own)
+	if err != nil {
+		return err
+	}
+	addrs, err := dumpAddrs(own)
+	if err != nil {
+		return err
+	}
+
+	r.links = make(map[int32]*linkState)
+	for index, link := range links {
+		r.links[index] = &linkState{linkMsg: link, addrs: make(map[string]addrMsg)}
+	}
+	for _, addr := range addrs {
+		if link, ok := r.links[addr.index]; ok {
+			link.addrs[addr.ip.String()] = addr
+		}
+	}
+	r.reindex()
+
+	logAddressMap(r.byIP)
+	return nil
+}
+
+// logAddressMap logs one line summarizing a reload
+func logAddressMap(byIP map[string]string) {
+	interfaces := make(map[string]bool)
+	for _, iface := range byIP {
+		interfaces[iface] = true
+	}
+	log.Printf("Mapped %d IP addresses to %d network interfaces", len(byIP), len(interfaces))
+}
+
+// reindex derives the address map from the link state; callers hold r.mu
+func (r *interfaceRegistry) reindex() {
+	byIP := make(map[string]string)
+	for _, link := range r.links {
+		if link.flags&syscall.IFF_UP == 0 || link.flags&syscall.IFF_LOOPBACK != 0 || ignoredInterface(link.name) {
+			continue
+		}
+		for ip := range link.addrs {
+			// Link-local IPv6 addresses are kept, as sockets report them without zone
+			if isLoopbackAddress(ip) || isUnspecifiedAddress(ip) {
+				continue
+			}
+			byIP[ip] = link.name
+		}
+	}
+	r.byIP = byIP
+}
+
+// apply updates the registry from one link or address notification
+func (r *interfaceRegistry) apply(m syscall.NetlinkMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.links == nil {
+		// Loaded without netlink; periodic rescans pick the change up
+		return
+	}
+
+	switch m.Header.Type {
+	case syscall.RTM_NEWLINK:
+		link, ok := parseLinkMsg(m)
+		if !ok {
+			return
+		}
+		existing, known := r.links[link.index]
+		switch {
+		case !known:
+			r.links[link.index] = &linkState{linkMsg: link, addrs: make(map[string]addrMsg)}
+			interfaceChanges.WithLabelValues("link_added").Inc()
+		case existing.linkMsg != link:
+			// Renames, up/down, MTU and master changes; statistics-only updates are ignored
+			existing.linkMsg = link
+			interfaceChanges.WithLabelValues("link_changed").Inc()
+		default:
+			return
+		}
+	case syscall.RTM_DELLINK:
+		link, ok := parseLinkMsg(m)
+		if !ok {
+			return
+		}
+		if _, known := r.links[link.index]; !known {
+			return
+		}
+		delete(r.links, link.index)
+		interfaceChanges.WithLabelValues("link_removed").Inc()
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		addr, ok := parseAddrMsg(m)
+		if !ok {
+			return
+		}
+		link, known := r.links[addr.index]
+		if !known {
+			return
+		}
+		if m.Header.Type == syscall.RTM_NEWADDR {
+			existing, known := link.addrs[addr.ip.String()]
+			if known && existing.label == addr.label && existing.secondary == addr.secondary {
+				return
+			}
+			link.addrs[addr.ip.String()] = addr
+			if known {
+				// A secondary promoted to primary after the primary was removed
+				interfaceChanges.WithLabelValues("address_changed").Inc()
+			} else {
+				interfaceChanges.WithLabelValues("address_added").Inc()
+			}
+		} else {
+			delete(link.addrs, addr.ip.String())
+			interfaceChanges.WithLabelValues("address_removed").Inc()
+		}
+	default:
+		return
+	}
+	r.reindex()
+}
+
+// monitor subscribes to link and address notifications, loads the registry and then
+// keeps applying changes in the background
+func (r *interfaceRegistry) monitor() {
+	fd, err := subscribeRtnetlink(rtmgrpLink | rtmgrpIPv4Ifaddr | rtmgrpIPv6Ifaddr)
+	if err != nil {
+		log.Printf("Warning: Could not subscribe to interface changes, rescanning at most every %s: %v", interfaceRefreshInterval, err)
+		return
+	}
+
+	// Subscribe before the initial dump so no change falls in between
+	r.mu.Lock()
+	r.monitored = true
+	r.load()
+	r.mu.Unlock()
+
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 8*os.Getpagesize())
+		for {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				if err == syscall.ENOBUFS {
+					// Notifications were dropped, so the incremental state is unreliable
+					r.mu.Lock()
+					r.load()
+					r.mu.Unlock()
+					continue
+				}
+				if err == syscall.EINTR {
+					continue
+				}
+				log.Printf("Warning: Interface change monitor stopped, rescanning at most every %s: %v", interfaceRefreshInterval, err)
+				r.mu.Lock()
+				r.monitored = false
+				r.mu.Unlock()
+				return
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range msgs {
+				r.apply(m)
+			}
+		}
+	}()
+}
+
+// interfaceDetails returns the metadata of every interface that is not container plumbing,
+// or nil when the registry was loaded without netlink
+func (r *interfaceRegistry) interfaceDetails() []interfaceInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stale() {
+		r.load()
+	}
+	if r.links == nil {
+		return nil
+	}
+
+	var infos []interfaceInfo
+	for _, link := range r.links {
+		if ignoredInterface(link.name) {
+			continue
+		}
+		info := interfaceInfo{linkMsg: link.linkMsg}
+		if master, ok := r.links[link.master]; ok {
+			info.masterName, info.masterKind = master.name, master.kind
+		}
+		for _, addr := range link.addrs {
+			info.addrs = append(info.addrs, addr)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// discoverInterfacesSysfs maps local addresses to interfaces without netlink or the ip
+// binary. Interface state comes from /sys/class/net and IPv6 addresses from
+// /proc/net/if_inet6. /proc/net/fib_trie lists the local IPv4 addresses but not their
+// interface, so each one is attributed to the directly connected route containing it.
+func discoverInterfacesSysfs() (map[string]string, error) {
+	entries, err := os.ReadDir(sysFilePath("class", "net"))
+	if err != nil {
+		return nil, err
+	}
+	usable := make(map[string]bool)
+	for _, entry := range entries {
+		name := entry.Name()
+		content, err := os.ReadFile(sysFilePath("class", "net", name, "flags"))
+		if err != nil {
+			continue
+		}
+		flags, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(string(content)), "0x"), 16, 32)
+		if err != nil {
+			continue
+		}
+		usable[name] = flags&syscall.IFF_UP != 0 && flags&syscall.IFF_LOOPBACK == 0 && !ignoredInterface(name)
+	}
+
+	byIP := make(map[string]string)
+	add := func(ip, iface string) {
+		if usable[iface] && !isLoopbackAddress(ip) && !isUnspecifiedAddress(ip) {
+			byIP[ip] = iface
+		}
+	}
+
+	connected := loadRouteTable(procFilePath("net", "route"), "").routes
+	for _, ip := range localIPv4Addresses(procFilePath("net", "fib_trie")) {
+		for _, r := range connected {
+			if !r.gateway && r.network.Contains(ip) {
+				add(ip.String(), r.iface)
+				break
+			}
+		}
+	}
+
+	for ip, iface := range ipv6Addresses(procFilePath("net", "if_inet6")) {
+		add(ip, iface)
+	}
+
+	return byIP, nil
+}
+
+// localIPv4Addresses returns the addresses marked "/32 host LOCAL" in /proc/net/fib_trie
+func localIPv4Addresses(file string) []net.IP {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	seen := make(map[string]struct{})
+	var addrs []net.IP
+	var leaf string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// Leaves look like "|-- 10.0.0.5", followed by one line per route type
+		if strings.HasPrefix(line, "|-- ") {
+			leaf = strings.TrimPrefix(line, "|-- ")
+			continue
+		}
+		if line != "/32 host LOCAL" || leaf == "" {
+			continue
+		}
+		// Both the Main and Local tables are listed
+		if _, ok := seen[leaf]; ok {
+			continue
+		}
+		if ip := net.ParseIP(leaf).To4(); ip != nil {
+			seen[leaf] = struct{}{}
+			addrs = append(addrs, ip)
+		}
+	}
+	return addrs
+}
+
+// ipv6Addresses maps the addresses in /proc/net/if_inet6 to their interface
+func ipv6Addresses(file string) map[string]string {
+	addrs := make(map[string]string)
+	f, err := os.Open(file)
+	if err != nil {
+		return addrs
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// address ifindex prefixlen scope flags name
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		b, err := hex.DecodeString(fields[0])
+		if err != nil || len(b) != net.IPv6len {
+			continue
+		}
+		addrs[net.IP(b).String()] = fields[5]
+	}
+	return addrs
+}
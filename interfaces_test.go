@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLocalIPv4Addresses(t *testing.T) {
+	var got []string
+	for _, ip := range localIPv4Addresses("testdata/proc/net/fib_trie") {
+		got = append(got, ip.String())
+	}
+	// Listed once although both tables hold them; network and broadcast leaves are skipped
+	want := []string{"10.0.0.5", "10.0.0.6", "10.0.1.7", "10.99.0.1", "127.0.0.1", "172.17.0.1", "192.168.50.7"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("localIPv4Addresses() = %v, want %v", got, want)
+	}
+	if addrs := localIPv4Addresses("testdata/proc/net/missing"); addrs != nil {
+		t.Errorf("localIPv4Addresses() of a missing file = %v, want nil", addrs)
+	}
+}
+
+func TestIPv6Addresses(t *testing.T) {
+	got := ipv6Addresses("testdata/proc/net/if_inet6")
+	want := map[string]string{
+		"::1":                       "lo",
+		"2001:db8::5":               "eth0",
+		"fe80::211:22ff:fe33:4455":  "eth0",
+		"2001:db8:0:32::7":          "eth1",
+		"fe80::a8b1:c2ff:fed3:e4f5": "veth1234",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ipv6Addresses() = %v, want %v", got, want)
+	}
+}
+
+func TestDiscoverInterfacesSysfs(t *testing.T) {
+	defer func(proc, sys string) { procfsRoot, sysfsRoot = proc, sys }(procfsRoot, sysfsRoot)
+	procfsRoot, sysfsRoot = "testdata/proc", "testdata/sys"
+
+	got, err := discoverInterfacesSysfs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Left out: loopback, eth1 (down), docker0 and veth devices, and 10.99.0.1 on wg0,
+	// which has no connected route to attribute it by
+	want := map[string]string{
+		"10.0.0.5":                 "eth0",
+		"10.0.0.6":                 "eth0",
+		"10.0.1.7":                 "eth0.100",
+		"2001:db8::5":              "eth0",
+		"fe80::211:22ff:fe33:4455": "eth0",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("discoverInterfacesSysfs() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lifecycleLabels identify the service side of a connection: the peer address plus
+// the well-known port (the remote port of outgoing, the local port of incoming connections)
+var lifecycleLabels = []string{"protocol", "direction", "peer_address", "port", "process_name", "netns"}
+
+// serviceLabels identify the listener (incoming) or remote service (outgoing) a connection
+// belongs to; service_address is the local address of incoming and the peer address of
+// outgoing connections
+var serviceLabels = []string{"protocol", "direction", "service_address", "port", "process_name", "netns"}
+
+// connectionAgeBuckets span short request/response connections to long-lived pools
+var connectionAgeBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 14400, 86400}
+
+// trackedSocket is a TCP connection seen during one scrape
+type trackedSocket struct {
+	key     string
+	inode   uint64
+	state   string
+	labels  []string
+	service []string
+}
+
+// newTrackedSocket builds the tracker entry for a connection. The key is the 4-tuple
+// within its namespace; the inode tells a reused 4-tuple apart from the same connection.
+func newTrackedSocket(conn tcpConnection, protocol, direction string) trackedSocket {
+	serviceAddress, port := conn.destinationAddress, conn.destinationPort
+	if conn.accepted {
+		serviceAddress, port = conn.sourceAddress, conn.sourcePort
+	}
+	return trackedSocket{
+		key:     strings.Join([]string{conn.netns, protocol, conn.sourceAddress, conn.sourcePort, conn.destinationAddress, conn.destinationPort}, "\xff"),
+		inode:   conn.inode,
+		state:   conn.state,
+		labels:  peerLabelValues(conn, protocol, direction),
+		service: []string{protocol, direction, serviceAddress, port, conn.processName, conn.netns},
+	}
+}
+
+// peerLabelValues returns the values for lifecycleLabels, in order
+func peerLabelValues(conn tcpConnection, protocol, direction string) []string {
+	port := conn.destinationPort
+	if conn.accepted {
+		port = conn.sourcePort
+	}
+	return []string{protocol, direction, conn.destinationAddress, port, conn.processName, conn.netns}
+}
+
+// open reports whether the connection is still open; TIME_WAIT and CLOSE sockets
+// only linger in the kernel after the connection has been closed
+func (s trackedSocket) open() bool {
+	return s.state != "TIME_WAIT" && s.state != "CLOSE"
+}
+
+// trackedConnection is the tracker's record of a connection across scrapes
+type trackedConnection struct {
+	inode      uint64
+	labels     []string
+	service    []string
+	firstSeen  time.Time
+	ageKnown   bool // false for connections that already existed when tracking started
+	closed     bool
+	generation uint64
+}
+
+// connectionTracker keeps the connections seen by previous scrapes so that the
+// connections opened and closed in between can be counted
+type connectionTracker struct {
+	mu          sync.Mutex
+	connections map[string]*trackedConnection
+	generation  uint64
+	seeded      bool
+
+	opened *prometheus.CounterVec
+	closed *prometheus.CounterVec
+	age    *prometheus.HistogramVec
+	oldest *prometheus.Desc
+	// oldestAge holds the oldest live connection per service as of the last update
+	oldestAge *labelMax
+}
+
+func newConnectionTracker() *connectionTracker {
+	return &connectionTracker{
+		connections: make(map[string]*trackedConnection),
+		opened: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "opened_total",
+			Help:      "Number of TCP connections observed opening since the exporter started",
+		}, lifecycleLabels),
+		closed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "closed_total",
+			Help:      "Number of TCP connections observed closing since the exporter started",
+		}, lifecycleLabels),
+		age: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "age_at_close_seconds",
+			Help:      "Age of TCP connections when they closed, measured from the scrape that first saw them",
+			Buckets:   connectionAgeBuckets,
+		}, serviceLabels),
+		oldest: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "oldest_age_seconds"),
+			"Age of the oldest open TCP connection per listener or remote service, at most the time since the exporter started",
+			serviceLabels,
+			nil,
+		),
+		oldestAge: newLabelMax(),
+	}
+}
+
+func (t *connectionTracker) describe(ch chan<- *prometheus.Desc) {
+	t.opened.Describe(ch)
+	t.closed.Describe(ch)
+	t.age.Describe(ch)
+	ch <- t.oldest
+}
+
+func (t *connectionTracker) collect(ch chan<- prometheus.Metric) {
+	t.opened.Collect(ch)
+	t.closed.Collect(ch)
+	t.age.Collect(ch)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.oldestAge.collect(ch, t.oldest)
+}
+
+// update compares the sockets of the current scrape with the previous ones. The first
+// scrape only seeds the table, so connections that existed before the exporter started
+// are not counted as opened, and their age is only known to be at least the time since.
+func (t *connectionTracker) update(sockets []trackedSocket, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.generation++
+	for _, s := range sockets {
+		existing, ok := t.connections[s.key]
+		if ok && existing.inode != 0 && s.inode != 0 && existing.inode != s.inode {
+			// Same 4-tuple, different socket: the old connection closed and a new one opened
+			t.closeConnection(existing, now)
+			ok = false
+		}
+
+		if !ok {
+			entry := &trackedConnection{
+				inode:      s.inode,
+				labels:     s.labels,
+				service:    s.service,
+				firstSeen:  now,
+				ageKnown:   t.seeded,
+				closed:     !s.open(),
+				generation: t.generation,
+			}
+			t.connections[s.key] = entry
+			if t.seeded {
+				t.opened.WithLabelValues(s.labels...).Inc()
+				if !s.open() {
+					// Opened and closed between two scrapes, first seen in TIME_WAIT
+					t.closed.WithLabelValues(s.labels...).Inc()
+				}
+			}
+			continue
+		}
+
+		existing.generation = t.generation
+		// The inode drops to 0 once a socket enters TIME_WAIT; keep the original
+		if s.inode != 0 {
+			existing.inode = s.inode
+		}
+		if !s.open() && !existing.closed {
+			t.closeConnection(existing, now)
+		}
+	}
+
+	// Connections that vanished since the last scrape have closed
+	t.oldestAge = newLabelMax()
+	for key, entry := range t.connections {
+		if entry.generation != t.generation {
+			if !entry.closed {
+				t.closeConnection(entry, now)
+			}
+			delete(t.connections, key)
+			continue
+		}
+		if !entry.closed {
+			t.oldestAge.max(now.Sub(entry.firstSeen).Seconds(), entry.service...)
+		}
+	}
+
+	t.seeded = true
+}
+
+// closeConnection records that a tracked connection closed; callers hold t.mu.
+// The close is only noticed at the next scrape, so ages are rounded up to it.
+func (t *connectionTracker) closeConnection(entry *trackedConnection, now time.Time) {
+	entry.closed = true
+	if !t.seeded {
+		return
+	}
+	t.closed.WithLabelValues(entry.labels...).Inc()
+	if entry.ageKnown {
+		t.age.WithLabelValues(entry.service...).Observe(now.Sub(entry.firstSeen).Seconds())
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// trackerCollector exposes a connectionTracker to a registry
+type trackerCollector struct {
+	*connectionTracker
+}
+
+func (c trackerCollector) Describe(ch chan<- *prometheus.Desc) { c.describe(ch) }
+func (c trackerCollector) Collect(ch chan<- prometheus.Metric) { c.collect(ch) }
+
+// gatherTracker returns the tracker's series keyed by metric name, direction and
+// peer or service address:port. Histograms are reported as their _count and _sum.
+func gatherTracker(t *testing.T, tracker *connectionTracker) map[string]float64 {
+	t.Helper()
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(trackerCollector{tracker})
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	series := make(map[string]float64)
+	prefix := namespace + "_" + subsystem + "_"
+	for _, family := range families {
+		name := family.GetName()[len(prefix):]
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, pair := range m.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			key := "{" + labels["direction"] + " " + labels["peer_address"] + labels["service_address"] + ":" + labels["port"] + "}"
+			switch {
+			case m.GetCounter() != nil:
+				series[name+key] = m.GetCounter().GetValue()
+			case m.GetGauge() != nil:
+				series[name+key] = m.GetGauge().GetValue()
+			case m.GetHistogram() != nil:
+				series[name+"_count"+key] = float64(m.GetHistogram().GetSampleCount())
+				series[name+"_sum"+key] = m.GetHistogram().GetSampleSum()
+			}
+		}
+	}
+	return series
+}
+
+// outgoing returns a connection from local port sport to the database at 10.0.0.5:5432
+func outgoing(sport string, inode uint64, state string) trackedSocket {
+	return newTrackedSocket(tcpConnection{
+		sourceAddress: "10.0.0.1", sourcePort: sport, destinationAddress: "10.0.0.5", destinationPort: "5432",
+		state: state, inode: inode, processName: "app", netns: "1",
+	}, "tcp", "outgoing")
+}
+
+// incoming returns a connection accepted on 10.0.0.1:443 from 10.0.0.9 and peer port dport
+func incoming(dport string, inode uint64, state string) trackedSocket {
+	return newTrackedSocket(tcpConnection{
+		sourceAddress: "10.0.0.1", sourcePort: "443", destinationAddress: "10.0.0.9", destinationPort: dport,
+		state: state, inode: inode, processName: "nginx", netns: "1", accepted: true,
+	}, "tcp", "incoming")
+}
+
+func TestConnectionTrackerLifecycle(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	steps := []struct {
+		name    string
+		now     time.Time
+		sockets []trackedSocket
+		want    map[string]float64
+	}{
+		{
+			// Connections that predate the exporter are not counted as opened
+			name:    "first scrape seeds",
+			now:     at(0),
+			sockets: []trackedSocket{outgoing("40001", 101, "ESTABLISHED"), incoming("50001", 201, "ESTABLISHED")},
+			want: map[string]float64{
+				"oldest_age_seconds{outgoing 10.0.0.5:5432}": 0,
+				"oldest_age_seconds{incoming 10.0.0.1:443}":  0,
+			},
+		},
+		{
+			name: "new connections are opened",
+			now:  at(10),
+			sockets: []trackedSocket{
+				outgoing("40001", 101, "ESTABLISHED"), outgoing("40002", 102, "ESTABLISHED"),
+				incoming("50001", 201, "ESTABLISHED"),
+			},
+			want: map[string]float64{
+				"opened_total{outgoing 10.0.0.5:5432}":       1,
+				"oldest_age_seconds{outgoing 10.0.0.5:5432}": 10,
+				"oldest_age_seconds{incoming 10.0.0.1:443}":  10,
+			},
+		},
+		{
+			// A seeded connection closes, but its age is unknown and stays out of the histogram
+			name:    "seeded connection vanishes",
+			now:     at(25),
+			sockets: []trackedSocket{outgoing("40002", 102, "ESTABLISHED"), incoming("50001", 201, "ESTABLISHED")},
+			want: map[string]float64{
+				"opened_total{outgoing 10.0.0.5:5432}":       1,
+				"closed_total{outgoing 10.0.0.5:5432}":       1,
+				"oldest_age_seconds{outgoing 10.0.0.5:5432}": 15,
+				"oldest_age_seconds{incoming 10.0.0.1:443}":  25,
+			},
+		},
+		{
+			// The inode drops to 0 in TIME_WAIT; the close is observed once, aged from its first scrape
+			name:    "connection enters TIME_WAIT",
+			now:     at(40),
+			sockets: []trackedSocket{outgoing("40002", 0, "TIME_WAIT"), incoming("50001", 201, "ESTABLISHED")},
+			want: map[string]float64{
+				"opened_total{outgoing 10.0.0.5:5432}":               1,
+				"closed_total{outgoing 10.0.0.5:5432}":               2,
+				"age_at_close_seconds_count{outgoing 10.0.0.5:5432}": 1,
+				"age_at_close_seconds_sum{outgoing 10.0.0.5:5432}":   30,
+				"oldest_age_seconds{incoming 10.0.0.1:443}":          40,
+			},
+		},
+		{
+			// Leaving TIME_WAIT is not a second close
+			name:    "TIME_WAIT socket vanishes",
+			now:     at(50),
+			sockets: []trackedSocket{incoming("50001", 201, "ESTABLISHED")},
+			want: map[string]float64{
+				"opened_total{outgoing 10.0.0.5:5432}":               1,
+				"closed_total{outgoing 10.0.0.5:5432}":               2,
+				"age_at_close_seconds_count{outgoing 10.0.0.5:5432}": 1,
+				"age_at_close_seconds_sum{outgoing 10.0.0.5:5432}":   30,
+				"oldest_age_seconds{incoming 10.0.0.1:443}":          50,
+			},
+		},
+		{
+			// A socket already in TIME_WAIT was opened and closed between two scrapes
+			name:    "first seen in TIME_WAIT",
+			now:     at(60),
+			sockets: []trackedSocket{incoming("50001", 201, "ESTABLISHED"), incoming("50002", 0, "TIME_WAIT")},
+			want: map[string]float64{
+				"opened_total{outgoing 10.0.0.5:5432}":               1,
+				"closed_total{outgoing 10.0.0.5:5432}":               2,
+				"opened_total{incoming 10.0.0.9:443}":                1,
+				"closed_total{incoming 10.0.0.9:443}":                1,
+				"age_at_close_seconds_count{outgoing 10.0.0.5:5432}": 1,
+				"age_at_close_seconds_sum{outgoing 10.0.0.5:5432}":   30,
+				"oldest_age_seconds{incoming 10.0.0.1:443}":          60,
+			},
+		},
+		{
+			// Same 4-tuple with a new inode: the old connection closed and a new one opened
+			name:    "4-tuple reused",
+			now:     at(70),
+			sockets: []trackedSocket{incoming("50001", 202, "ESTABLISHED")},
+			want: map[string]float64{
+				"opened_total{outgoing 10.0.0.5:5432}":               1,
+				"closed_total{outgoing 10.0.0.5:5432}":               2,
+				"opened_total{incoming 10.0.0.9:443}":                2,
+				"closed_total{incoming 10.0.0.9:443}":                2,
+				"age_at_close_seconds_count{outgoing 10.0.0.5:5432}": 1,
+				"age_at_close_seconds_sum{outgoing 10.0.0.5:5432}":   30,
+				"oldest_age_seconds{incoming 10.0.0.1:443}":          0,
+			},
+		},
+		{
+			name:    "all connections closed",
+			now:     at(100),
+			sockets: nil,
+			want: map[string]float64{
+				"opened_total{outgoing 10.0.0.5:5432}":               1,
+				"closed_total{outgoing 10.0.0.5:5432}":               2,
+				"opened_total{incoming 10.0.0.9:443}":                2,
+				"closed_total{incoming 10.0.0.9:443}":                3,
+				"age_at_close_seconds_count{outgoing 10.0.0.5:5432}": 1,
+				"age_at_close_seconds_sum{outgoing 10.0.0.5:5432}":   30,
+				"age_at_close_seconds_count{incoming 10.0.0.1:443}":  1,
+				"age_at_close_seconds_sum{incoming 10.0.0.1:443}":    30,
+			},
+		},
+	}
+
+	tracker := newConnectionTracker()
+	for _, step := range steps {
+		tracker.update(step.sockets, step.now)
+		if got := gatherTracker(t, tracker); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: series = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestConnectionTrackerOldestAge(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tracker := newConnectionTracker()
+	tracker.update(nil, start)
+
+	// The gauge follows the oldest open connection of each service
+	tracker.update([]trackedSocket{outgoing("40001", 101, "ESTABLISHED")}, start.Add(10*time.Second))
+	tracker.update([]trackedSocket{outgoing("40001", 101, "ESTABLISHED"), outgoing("40002", 102, "ESTABLISHED")}, start.Add(20*time.Second))
+	if got := gatherTracker(t, tracker)["oldest_age_seconds{outgoing 10.0.0.5:5432}"]; got != 10 {
+		t.Errorf("oldest_age_seconds with both open = %v, want 10", got)
+	}
+
+	tracker.update([]trackedSocket{outgoing("40002", 102, "ESTABLISHED")}, start.Add(50*time.Second))
+	if got := gatherTracker(t, tracker)["oldest_age_seconds{outgoing 10.0.0.5:5432}"]; got != 30 {
+		t.Errorf("oldest_age_seconds after the oldest closed = %v, want 30", got)
+	}
+
+	// Closed connections lingering in TIME_WAIT are no longer open
+	tracker.update([]trackedSocket{outgoing("40002", 0, "TIME_WAIT")}, start.Add(60*time.Second))
+	if got, ok := gatherTracker(t, tracker)["oldest_age_seconds{outgoing 10.0.0.5:5432}"]; ok {
+		t.Errorf("oldest_age_seconds without open connections = %v, want no series", got)
+	}
+}
@@ -1,688 +1,383 @@
-
-
 package main
 
-
 import (
-   "bufio"
-   "encoding/hex"
-   "fmt"
-   "log"
-   "net"
-   "net/http"
-   "os"
-   "os/exec"
-   "strconv"
-   "strings"
-
-   "github.com/prometheus/client_golang/prometheus"
-   "github.com/prometheus/client_golang/prometheus/promhttp"
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// directionForEstablishedIncoming returns true if the sourcePort matches a LISTEN port (incoming ESTABLISHED)
-func directionForEstablishedIncoming(sourcePort string, listenPorts map[string]struct{}) bool {
-	_, ok := listenPorts[sourcePort]
-	return ok
-}
-
-// interfaceCache stores the mapping of IP addresses to interface names
-var interfaceCache map[string]string
-
-// getNetworkInterfaces builds a map of IP addresses to interface names
-func getNetworkInterfaces() (map[string]string, error) {
-	interfaces, err := net.Interfaces()
-	if err != nil {
-		// Handle the specific netlink error gracefully
-		if strings.Contains(err.Error(), "address family not supported") || 
-		   strings.Contains(err.Error(), "netlinkrib") {
-			log.Printf("Warning: netlink error detected, falling back to manual interface detection: %v", err)
-			return getNetworkInterfacesManual()
-		}
-		return nil, fmt.Errorf("failed to get network interfaces: %v", err)
-	}
-
-	ipToInterface := make(map[string]string)
-	successCount := 0
-
-	for _, iface := range interfaces {
-		// Skip interfaces that are down, loopback, or point-to-point
-		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
-			continue
-		}
-
-		// Skip certain interface types that commonly cause issues (but keep virbr and bond interfaces)
-		if strings.Contains(iface.Name, "docker") || 
-		   (strings.Contains(iface.Name, "veth") && !strings.Contains(iface.Name, "vnet")) ||
-		   (strings.Contains(iface.Name, "br-") && !strings.Contains(iface.Name, "virbr")) {
-			continue
-		}
-
-		// Explicitly include bonding interfaces (bond0, bond1, etc.)
-		isBondInterface := strings.HasPrefix(iface.Name, "bond")
-		if isBondInterface {
-			log.Printf("Debug: Found bonding interface: %s", iface.Name)
-			// Get bonding info for this interface
-			bondInfo := getBondingInterfaceInfo()
-			if slaves, exists := bondInfo[iface.Name]; exists {
-				log.Printf("Debug: Bonding interface %s is active with slaves: %v", iface.Name, slaves)
-			}
-		}
-
-		// Get addresses for this interface, but handle errors gracefully
-		addrs, err := iface.Addrs()
-		if err != nil {
-			// Log warning but continue with other interfaces
-			log.Printf("Warning: Could not get addresses for interface %s: %v", iface.Name, err)
-			continue
-		}
-
-		for _, addr := range addrs {
-			// Handle the address parsing more carefully
-			var ip net.IP
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			default:
-				// Skip unknown address types
-				log.Printf("Debug: Skipping unknown address type %T on interface %s", v, iface.Name)
-				continue
-			}
-
-			if ip != nil {
-				// Only map IPv4 addresses for now (skip IPv6 to avoid protocol issues)
-				if ip.To4() != nil && !ip.IsLoopback() && !ip.IsUnspecified() {
-					ipStr := ip.String()
-					
-					// Check if this interface already has an IP mapped (multiple IPs scenario)
-					existingIPs := []string{}
-					for existingIP, existingIface := range ipToInterface {
-						if existingIface == iface.Name {
-							existingIPs = append(existingIPs, existingIP)
-						}
-					}
-					
-					ipToInterface[ipStr] = iface.Name
-					successCount++
-					
-					if len(existingIPs) > 0 {
-						log.Printf("Debug: Multiple IPs detected on %s - Added %s (existing: %v)", iface.Name, ipStr, existingIPs)
-					}
-				}
-			}
-		}
-	}
-
-	// If we couldn't get any interfaces, log a warning but don't fail completely
-	if successCount == 0 {
-		log.Printf("Warning: No usable network interfaces found, connection interface detection may be limited")
-	} else {
-		log.Printf("Successfully mapped %d IP addresses to network interfaces", successCount)
-		// Show interface statistics and multiple IP detection
-		getInterfaceStatistics(ipToInterface)
-	}
-
-	return ipToInterface, nil
-}
+// procfsRoot and sysfsRoot are where procfs and sysfs are mounted; override them with
+// --path.procfs/--path.sysfs when running in a container with the host's /proc and /sys mounted elsewhere
+var (
+	procfsRoot = "/proc"
+	sysfsRoot  = "/sys"
+)
 
-// getNetworkInterfacesManual uses system commands as fallback when Go's net package fails
-func getNetworkInterfacesManual() (map[string]string, error) {
-	ipToInterface := make(map[string]string)
-	
-	// Use ip command to get interface information - try multiple paths
-	var cmd *exec.Cmd
-	var output []byte
-	var err error
-	
-	// Try different ip command paths (systemd environment may have limited PATH)
-	ipPaths := []string{"ip", "/usr/bin/ip", "/bin/ip", "/sbin/ip", "/usr/sbin/ip"}
-	
-	for _, ipPath := range ipPaths {
-		cmd = exec.Command(ipPath, "-4", "addr", "show")
-		output, err = cmd.Output()
-		if err == nil {
-			log.Printf("Debug: Successfully used ip command at: %s", ipPath)
-			break
-		}
-		log.Printf("Debug: Failed to run ip at %s: %v", ipPath, err)
-	}
-	
-	if err != nil {
-		log.Printf("Warning: Could not run 'ip addr show' from any location, trying alternative method: %v", err)
-		return getNetworkInterfacesFallback(), nil
-	}
-	
-	lines := strings.Split(string(output), "\n")
-	var currentInterface string
-	
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		
-		// Parse interface names (e.g., "2: eth0: <BROADCAST,MULTICAST,UP,LOWER_UP>")
-		if strings.Contains(line, ": <") && !strings.HasPrefix(line, "inet") {
-			parts := strings.Split(line, ":")
-			if len(parts) >= 2 {
-				currentInterface = strings.TrimSpace(parts[1])
-			}
-		}
-		
-		// Parse IP addresses (e.g., "inet 192.168.1.100/24 brd 192.168.1.255 scope global eth0" or "inet 172.20.164.118/24 brd 172.20.164.255 scope global secondary eth0:gssapt11")
-		if strings.HasPrefix(line, "inet ") && currentInterface != "" {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				ipCidr := parts[1]
-				// Extract IP from CIDR notation
-				if slashPos := strings.Index(ipCidr, "/"); slashPos > 0 {
-					ip := ipCidr[:slashPos]
-					
-					// Determine interface label and type
-					var interfaceLabel string
-					var isSecondary bool
-					
-					// Check if this is a secondary IP with a label (e.g., eth0:gssapt11)
-					for _, part := range parts {
-						if strings.Contains(part, ":") && strings.Contains(part, currentInterface) {
-							interfaceLabel = part
-							break
-						} else if part == "secondary" {
-							isSecondary = true
-						}
-					}
-					
-					// If no specific label found, use the current interface
-					if interfaceLabel == "" {
-						interfaceLabel = currentInterface
-					}
-					
-					// Skip loopback
-					if ip != "127.0.0.1" && currentInterface != "lo" {
-						ipToInterface[ip] = currentInterface // Always map to base interface name for consistency
-						
-						if strings.HasPrefix(currentInterface, "bond") {
-							if isSecondary {
-								log.Printf("Debug: Manual detection - Secondary IP on bonding interface: %s mapped to %s (label: %s)", ip, currentInterface, interfaceLabel)
-							} else {
-								log.Printf("Debug: Manual detection - Primary IP on bonding interface: %s mapped to %s", ip, currentInterface)
-							}
-						} else {
-							if isSecondary {
-								log.Printf("Debug: Manual detection - Secondary IP detected: %s mapped to %s (label: %s)", ip, currentInterface, interfaceLabel)
-							} else {
-								log.Printf("Debug: Manual detection - Primary IP: %s mapped to interface %s", ip, currentInterface)
-							}
-						}
-					}
-				}
-			}
-		}
-	}
-	
-	log.Printf("Manual interface detection found %d IP mappings", len(ipToInterface))
-	if len(ipToInterface) > 0 {
-		getInterfaceStatistics(ipToInterface)
-	}
-	return ipToInterface, nil
+// procFilePath returns a path below the procfs mount point
+func procFilePath(parts ...string) string {
+	return filepath.Join(append([]string{procfsRoot}, parts...)...)
 }
 
-// getBondingInterfaceInfo returns information about bonding interfaces and their slaves
-func getBondingInterfaceInfo() map[string][]string {
-	bondInfo := make(map[string][]string)
-	
-	// Check for bonding interfaces in /proc/net/bonding/
-	bondDir := "/proc/net/bonding"
-	if entries, err := os.ReadDir(bondDir); err == nil {
-		for _, entry := range entries {
-			if entry.IsDir() {
-				continue
-			}
-			
-			bondName := entry.Name()
-			bondPath := fmt.Sprintf("%s/%s", bondDir, bondName)
-			
-			if content, err := os.ReadFile(bondPath); err == nil {
-				slaves := []string{}
-				lines := strings.Split(string(content), "\n")
-				
-				for _, line := range lines {
-					line = strings.TrimSpace(line)
-					if strings.HasPrefix(line, "Slave Interface:") {
-						parts := strings.Fields(line)
-						if len(parts) >= 3 {
-							slaves = append(slaves, parts[2])
-						}
-					}
-				}
-				
-				if len(slaves) > 0 {
-					bondInfo[bondName] = slaves
-					log.Printf("Debug: Bonding interface %s has slaves: %v", bondName, slaves)
-				}
-			}
-		}
-	}
-	
-	return bondInfo
+// sysFilePath returns a path below the sysfs mount point
+func sysFilePath(parts ...string) string {
+	return filepath.Join(append([]string{sysfsRoot}, parts...)...)
 }
 
-// getInterfaceStatistics returns statistics about interface usage
-func getInterfaceStatistics(ipToInterface map[string]string) {
-	interfaceCount := make(map[string]int)
-	interfaceIPs := make(map[string][]string)
-	
-	for ip, iface := range ipToInterface {
-		interfaceCount[iface]++
-		interfaceIPs[iface] = append(interfaceIPs[iface], ip)
-	}
-	
-	log.Printf("Interface statistics:")
-	for iface, count := range interfaceCount {
-		if count > 1 {
-			log.Printf("  %s: %d IPs (%v) - Multiple IP configuration detected", iface, count, interfaceIPs[iface])
-		} else {
-			log.Printf("  %s: %d IP (%v)", iface, count, interfaceIPs[iface])
-		}
-	}
+// isLoopbackAddress reports whether ip is the IPv4 or IPv6 loopback address
+func isLoopbackAddress(ip string) bool {
+	return ip == "127.0.0.1" || ip == "::1"
 }
 
-// getNetworkInterfacesFallback provides hardcoded common interface mappings as last resort
-func getNetworkInterfacesFallback() map[string]string {
-	log.Printf("Warning: Using fallback interface detection with common defaults")
-	
-	// Create a basic mapping with common interface names
-	ipToInterface := make(map[string]string)
-	
-	// Try to determine the primary interface from routing table
-	var routeOutput []byte
-	var routeErr error
-	
-	// Try different ip command paths for routing
-	for _, ipPath := range []string{"ip", "/usr/bin/ip", "/bin/ip", "/sbin/ip", "/usr/sbin/ip"} {
-		routeCmd := exec.Command(ipPath, "route", "show", "default")
-		routeOutput, routeErr = routeCmd.Output()
-		if routeErr == nil {
-			break
-		}
-	}
-	
-	if routeErr == nil {
-		lines := strings.Split(string(routeOutput), "\n")
-		for _, line := range lines {
-			if strings.Contains(line, "default via") {
-				parts := strings.Fields(line)
-				for i, part := range parts {
-					if part == "dev" && i+1 < len(parts) {
-						primaryIface := parts[i+1]
-						// Assign common IP ranges to the primary interface
-						ipToInterface["192.168.200.179"] = primaryIface
-						
-						if strings.HasPrefix(primaryIface, "bond") {
-							log.Printf("Debug: Fallback - Bonding interface detected as primary: %s", primaryIface)
-						} else {
-							log.Printf("Debug: Fallback - Primary interface detected as %s", primaryIface)
-						}
-						break
-					}
-				}
-			}
-		}
-	}
-	
-	// Add common bridge interfaces
-	ipToInterface["172.16.10.1"] = "virbr2"
-	ipToInterface["192.168.100.1"] = "virbr1"
-	
-	return ipToInterface
+// isUnspecifiedAddress reports whether ip is the IPv4 or IPv6 wildcard address
+func isUnspecifiedAddress(ip string) bool {
+	return ip == "0.0.0.0" || ip == "::"
 }
 
-// getDetailedInterfaceInfo returns detailed information about an IP's interface assignment
-func getDetailedInterfaceInfo(ip string) (interfaceName string, isSecondary bool) {
-	if interfaceCache == nil {
-		return "unknown", false
-	}
-	
-	// Get the base interface name
-	if iface, exists := interfaceCache[ip]; exists {
-		// Check if this IP is one of multiple IPs on the same interface
-		ipCount := 0
-		for _, cachedIface := range interfaceCache {
-			if cachedIface == iface {
-				ipCount++
-			}
-		}
-		
-		// If there are multiple IPs on this interface, this might be a secondary IP
-		// We can't definitively determine primary vs secondary from Go's net package alone,
-		// but we can detect the multiple IP scenario
-		isSecondary = ipCount > 1
-		
-		return iface, isSecondary
-	}
-	
-	return "unknown", false
-}
-
-// getAvailableIPs returns list of IPs currently in the interface cache for debugging
-func getAvailableIPs() []string {
-	ips := make([]string, 0, len(interfaceCache))
-	for ip := range interfaceCache {
-		ips = append(ips, ip)
+// ipFamily returns "ipv4" or "ipv6" for an address string; IPv4-mapped IPv6 addresses count as IPv4
+func ipFamily(ip string) string {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP != nil && parsedIP.To4() == nil {
+		return "ipv6"
 	}
-	return ips
+	return "ipv4"
 }
 
-// getInterfaceForConnection determines the interface for a connection based on source and destination
+// getInterfaceForConnection determines the interface for a connection in the exporter's own
+// namespace: the interface holding the source address, otherwise the routing decision,
+// which attributes wildcard listeners to the interface of the default route
 func getInterfaceForConnection(sourceIP, destIP string) string {
-	// For loopback connections, return loopback interface first
-	if sourceIP == "127.0.0.1" || destIP == "127.0.0.1" {
+	if isLoopbackAddress(sourceIP) || isLoopbackAddress(destIP) {
 		return "lo"
 	}
-
-	// For listening connections (destination 0.0.0.0), handle specially
-	if destIP == "0.0.0.0" {
-		// If source is 0.0.0.0, it's listening on all interfaces - use primary
-		if sourceIP == "0.0.0.0" {
-			primary := getPrimaryInterface()
-			log.Printf("Debug: 0.0.0.0 listener mapped to primary interface: %s", primary)
-			return primary
-		}
-		// If source has a specific IP, use that interface
-		result := getInterfaceForIP(sourceIP)
-		log.Printf("Debug: Listener on %s mapped to interface: %s", sourceIP, result)
-		return result
-	}
-
-	// For established connections, prioritize source IP interface
-	if sourceIP != "0.0.0.0" && sourceIP != "127.0.0.1" {
+	if !isUnspecifiedAddress(sourceIP) {
 		if iface := getInterfaceForIP(sourceIP); iface != "unknown" {
 			return iface
 		}
 	}
-
-	// For outbound connections to external IPs, determine interface by routing
-	if !isLocalIP(destIP) {
-		if iface := getInterfaceForDestination(destIP); iface != "unknown" {
-			return iface
-		}
-	}
-
-	// Fallback to primary interface
-	return getPrimaryInterface()
-}
-
-// isLocalIP checks if an IP is in private/local ranges
-func isLocalIP(ip string) bool {
-	if ip == "127.0.0.1" || ip == "0.0.0.0" {
-		return true
-	}
-	
-	parsedIP := net.ParseIP(ip)
-	if parsedIP == nil {
-		return false
-	}
-
-	// Check for private IP ranges
-	return parsedIP.IsLoopback() ||
-		strings.HasPrefix(ip, "192.168.") ||
-		strings.HasPrefix(ip, "172.16.") ||
-		strings.HasPrefix(ip, "10.") ||
-		strings.HasPrefix(ip, "169.254.") // Link-local
+	return ownRoutes.get().interfaceForConnection(sourceIP, destIP)
 }
 
 // getInterfaceForIP returns the interface name for a given IP address
 func getInterfaceForIP(ip string) string {
-	// Initialize or refresh interface cache if needed
-	if interfaceCache == nil {
-		var err error
-		interfaceCache, err = getNetworkInterfaces()
-		if err != nil {
-			log.Printf("Error getting network interfaces: %v", err)
-			return "unknown"
-		}
-	}
-
-	// Check exact IP match first
-	if iface, exists := interfaceCache[ip]; exists {
+	// The registry follows interface changes itself, so a miss needs no rescan
+	if iface, exists := localInterfaces.lookup(ip); exists {
+		interfaceCacheLookups.WithLabelValues("hit").Inc()
 		return iface
 	}
+	interfaceCacheLookups.WithLabelValues("miss").Inc()
 
-	// Debug: log when IP is not found in cache
-	log.Printf("Debug: IP %s not found in cache, available IPs: %v", ip, getAvailableIPs())
-
-	// Handle special addresses
-	switch ip {
-	case "127.0.0.1":
+	if isLoopbackAddress(ip) {
 		return "lo"
-	case "0.0.0.0":
-		// For 0.0.0.0 (listen on all), find the primary interface
-		// Try to find the default route interface or first non-loopback interface
-		return getPrimaryInterface()
-	default:
-		// If IP not found in cache, try to refresh the cache once
-		// This handles dynamic interface changes (containers, etc.)
-		var err error
-		interfaceCache, err = getNetworkInterfaces()
-		if err != nil {
-			log.Printf("Error refreshing network interfaces: %v", err)
-			return "unknown"
-		}
-		
-		// Check again after refresh
-		if iface, exists := interfaceCache[ip]; exists {
-			return iface
-		}
-		
-		// If still not found, try to determine interface by checking if IP is in same subnet as any interface
-		if iface := getInterfaceBySubnet(ip); iface != "unknown" {
-			return iface
-		}
 	}
 
+	// Otherwise use the interface of the address's connected route. Every IPv6-enabled
+	// interface carries fe80::/64, so that would just pick one for a link-local address.
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil || (parsedIP.To4() == nil && parsedIP.IsLinkLocalUnicast()) {
+		return "unknown"
+	}
+	if r, ok := ownRoutes.get().lookup(parsedIP); ok && r.iface != "" {
+		return r.iface
+	}
 	return "unknown"
 }
 
-// getInterfaceForDestination determines which interface would be used for outbound connections to a destination
-func getInterfaceForDestination(destIP string) string {
-	// Use ip route get to determine which interface would be used
-	var output []byte
-	var err error
-	
-	// Try different ip command paths
-	for _, ipPath := range []string{"ip", "/usr/bin/ip", "/bin/ip", "/sbin/ip", "/usr/sbin/ip"} {
-		cmd := exec.Command(ipPath, "route", "get", destIP)
-		output, err = cmd.Output()
-		if err == nil {
-			break
-		}
+const (
+	namespace = "network"
+	subsystem = "connections"
+)
+
+// connectionLabels is the label set shared by network_connections_info and the per-connection metrics
+var connectionLabels = []string{"source_address", "source_port", "destination_address", "destination_port", "state", "interface", "vrf", "protocol", "direction", "process_name", "ip_family", "pid", "process_exe", "netns", "netns_name"}
+
+// collectorOptions holds the command-line settings of networkConnectionsCollector
+type collectorOptions struct {
+	tcpStates   uint32
+	withTCPInfo bool
+	allNetns    bool
+	perSocket   bool       // export one series per socket; aggregates are always exported
+	ephemeral   *portRange // collapse ephemeral ports in this range; nil disables collapsing
+	cri         *criClient // nil unless --collector.cri-endpoint is set
+	lifecycle   bool       // track connections across scrapes for the opened/closed counters
+	queues      bool       // export socket receive/send queues
+	queueMin    uint64     // only export queues of sockets with more than this many bytes queued
+}
+
+type networkConnectionsCollector struct {
+	metric          *prometheus.Desc
+	process         *prometheus.Desc
+	listenOverflows *prometheus.Desc
+	listenDrops     *prometheus.Desc
+	source          socketSource
+	opts            collectorOptions
+	tcpInfo         *tcpInfoMetrics // nil unless --collector.tcp-info is enabled
+	aggregate       *aggregateMetrics
+	tracker         *connectionTracker  // nil unless --collector.lifecycle is enabled
+	queues          *socketQueueMetrics // nil unless --collector.socket-queues is enabled
+	ownNetns        netNamespace
+}
+
+func newNetworkConnectionsCollector(source socketSource, opts collectorOptions) *networkConnectionsCollector {
+	c := &networkConnectionsCollector{
+		metric: prometheus.NewDesc(
+			"network_connections_info",
+			"Information about network connections",
+			connectionLabels,
+			nil,
+		),
+		process: prometheus.NewDesc(
+			"network_connections_process_info",
+			"Information about processes owning network connections, joinable on pid",
+			[]string{"pid", "process_name", "process_exe", "systemd_unit", "cgroup", "container_id", "pod", "k8s_namespace"},
+			nil,
+		),
+		listenOverflows: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "listen_overflows_total"),
+			"Times a listening socket's accept queue was full (TcpExt ListenOverflows)",
+			[]string{"netns"},
+			nil,
+		),
+		listenDrops: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "listen_drops_total"),
+			"SYNs and handshake completions dropped by listening sockets, including overflows (TcpExt ListenDrops)",
+			[]string{"netns"},
+			nil,
+		),
+		source:    source,
+		opts:      opts,
+		aggregate: newAggregateMetrics(),
+		ownNetns:  currentNetNamespace(),
+	}
+	if opts.withTCPInfo && opts.perSocket && opts.ephemeral == nil {
+		c.tcpInfo = newTCPInfoMetrics()
+	}
+	if opts.lifecycle {
+		c.tracker = newConnectionTracker()
+	}
+	if opts.queues {
+		c.queues = newSocketQueueMetrics(opts.perSocket, opts.queueMin)
+	}
+	return c
+}
+
+func (c *networkConnectionsCollector) Describe(ch chan<- *prometheus.Desc) {
+	if c.opts.perSocket {
+		ch <- c.metric
 	}
-	
-	if err != nil {
-		return "unknown"
+	ch <- c.process
+	ch <- c.listenOverflows
+	ch <- c.listenDrops
+	c.aggregate.describe(ch)
+	if c.tcpInfo != nil {
+		c.tcpInfo.describe(ch)
 	}
-
-	// Parse the output to extract the interface
-	// Example output: "192.168.1.1 via 192.168.1.1 dev eth0 src 192.168.1.100 uid 0"
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		fields := strings.Fields(line)
-		for i, field := range fields {
-			if field == "dev" && i+1 < len(fields) {
-				return fields[i+1]
-			}
-		}
+	if c.tracker != nil {
+		c.tracker.describe(ch)
+	}
+	if c.queues != nil {
+		c.queues.describe(ch)
 	}
+}
 
-	return "unknown"
+func (c *networkConnectionsCollector) Collect(ch chan<- prometheus.Metric) {
+	defer observeDuration("total", time.Now())
+
+	namespaces := []netNamespace{c.ownNetns}
+	if c.opts.allNetns {
+		start := time.Now()
+		namespaces = discoverNetNamespaces()
+		observeDuration("netns", start)
+	}
+
+	// Socket inodes are unique across namespaces, so one process scan covers all of them
+	start := time.Now()
+	socketProcesses := getSocketProcessMap()
+	observeDuration("processes", start)
+	aggregator := c.aggregate.newAggregator()
+	// Sockets with identical labels (SO_REUSEPORT, collapsed ephemeral ports) are
+	// summed into one network_connections_info series
+	sockets := newLabelCounter()
+	var queues *socketQueues
+	if c.queues != nil {
+		queues = c.queues.newQueues()
+	}
+	var tracked []trackedSocket
+	owners := make(map[int]processInfo)
+	for _, ns := range namespaces {
+		tracked = append(tracked, c.collectNamespace(ch, ns, socketProcesses, owners, aggregator, sockets, queues)...)
+	}
+	aggregator.collect(ch)
+	sockets.collect(ch, c.metric)
+	if queues != nil {
+		queues.collect(ch)
+	}
+	if c.tracker != nil {
+		c.tracker.update(tracked, time.Now())
+		c.tracker.collect(ch)
+	}
+
+	// One info series per process owning at least one exported TCP or UDP socket
+	for _, proc := range owners {
+		var pod podInfo
+		if c.opts.cri != nil && proc.containerID != "" {
+			pod = c.opts.cri.podForContainer(proc.containerID)
+		}
+		ch <- prometheus.MustNewConstMetric(c.process, prometheus.GaugeValue, 1, strconv.Itoa(proc.pid), proc.name, proc.exe, proc.systemdUnit, proc.cgroup, proc.containerID, pod.name, pod.namespace)
+	}
 }
 
-// getInterfaceBySubnet checks if an IP belongs to any interface's subnet
-func getInterfaceBySubnet(targetIP string) string {
-	interfaces, err := net.Interfaces()
+// collectNamespace exports the sockets of one network namespace and adds them to the aggregates,
+// recording the processes owning exported sockets in owners by PID.
+// It returns the TCP connections for the lifecycle tracker, or nil if tracking is disabled.
+func (c *networkConnectionsCollector) collectNamespace(ch chan<- prometheus.Metric, ns netNamespace, socketProcesses map[uint64]processInfo, owners map[int]processInfo, aggregator *connectionAggregator, sockets *labelCounter, queues *socketQueues) []trackedSocket {
+	// LISTEN sockets are always requested, even when filtered out of the output,
+	// because direction classification depends on them. The lifecycle tracker needs
+	// every state to notice connections closing.
+	states := c.opts.tcpStates | tcpStateBit("LISTEN")
+	if c.tracker != nil {
+		states = tcpStatesAll
+	}
+	start := time.Now()
+	tcpConnections, err := c.source.TCPSockets(ns, states)
+	observeDuration("tcp", start)
 	if err != nil {
-		return "unknown"
+		log.Printf("Error getting TCP connections from %s backend (netns %s): %v", c.source.Name(), ns.label(), err)
+		countError("tcp", "read")
 	}
+	socketsParsed.WithLabelValues("tcp").Add(float64(len(tcpConnections)))
 
-	ip := net.ParseIP(targetIP)
-	if ip == nil {
-		return "unknown"
+	start = time.Now()
+	udpConnections, err := c.source.UDPSockets(ns)
+	observeDuration("udp", start)
+	if err != nil {
+		log.Printf("Error getting UDP connections from %s backend (netns %s): %v", c.source.Name(), ns.label(), err)
+		countError("udp", "read")
 	}
+	socketsParsed.WithLabelValues("udp").Add(float64(len(udpConnections)))
 
-	for _, iface := range interfaces {
-		// Skip interfaces that are down or loopback
-		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
-			continue
-		}
-
-		addrs, err := iface.Addrs()
-		if err != nil {
-			continue
-		}
-
-		for _, addr := range addrs {
-			if ipnet, ok := addr.(*net.IPNet); ok {
-				if ipnet.Contains(ip) {
-					return iface.Name
-				}
-			}
-		}
+	// Interfaces of other namespaces are not visible to us, so resolve them through
+	// that namespace's own routing table instead
+	routes, resolveUnbound := ownRoutes.get(), getInterfaceForConnection
+	if !ns.own() {
+		routes = loadNamespaceRoutes(ns)
+		resolveUnbound = routes.interfaceForConnection
+	}
+	resolveInterface := func(conn tcpConnection) (string, string) {
+		return routes.socketInterface(conn, resolveUnbound)
 	}
 
-	return "unknown"
-}
+	// Attribute sockets to processes and interfaces
+	annotateConnections(tcpConnections, ns, socketProcesses, resolveInterface)
+	annotateConnections(udpConnections, ns, socketProcesses, resolveInterface)
 
-// getPrimaryInterface returns the primary network interface name
-func getPrimaryInterface() string {
-	// Try to find interfaces with IPv4 addresses (excluding loopback)
-	interfaces, err := net.Interfaces()
-	if err != nil {
-		return "unknown"
-	}
+	// Index listening (address, port) pairs for direction classification
+	listeners := make(listenerIndex)
+	listeners.add(tcpConnections, "tcp")
+	listeners.add(udpConnections, "udp")
 
-	// First priority: bonding interfaces (bond0, bond1, etc.) as they're typically primary in enterprise
-	for _, iface := range interfaces {
-		// Skip loopback and down interfaces
-		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+	// Collect TCP connections with direction label
+	var tracked []trackedSocket
+	for _, conn := range tcpConnections {
+		direction := connectionDirection(&conn, "tcp", listeners)
+		// Accept queues are exported even when LISTEN sockets are filtered out
+		if conn.state == "LISTEN" {
+			aggregator.addListener(conn)
+		}
+		// Both ends of a loopback connection are local sockets; only the connecting one is
+		// tracked so the connection is counted once
+		if c.tracker != nil && conn.state != "LISTEN" && (direction != "local" || !conn.accepted) {
+			tracked = append(tracked, newTrackedSocket(conn, "tcp", direction))
+		}
+		if c.opts.tcpStates&tcpStateBit(conn.state) == 0 {
 			continue
 		}
-		
-		// Highest priority: bonding interfaces
-		if strings.HasPrefix(iface.Name, "bond") {
-			addrs, err := iface.Addrs()
-			if err != nil {
-				continue
-			}
-			// Check if it has an IPv4 address
-			for _, addr := range addrs {
-				if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
-					log.Printf("Debug: Selected bonding interface %s as primary", iface.Name)
-					return iface.Name
-				}
+		aggregator.add(conn, "tcp", direction)
+		addSocketOwner(owners, conn, socketProcesses)
+		addQueues := queues != nil && tcpQueueStates&tcpStateBit(conn.state) != 0
+		if !c.opts.perSocket {
+			if addQueues {
+				queues.add(conn, peerLabelValues(conn, "tcp", direction))
 			}
+			continue
 		}
-	}
 
-	// Second priority: ethernet interfaces (eth0, en*, etc.)
-	for _, iface := range interfaces {
-		// Skip loopback and down interfaces
-		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
-			continue
+		if c.opts.ephemeral != nil {
+			c.opts.ephemeral.collapse(&conn, "tcp")
 		}
-		
-		// Prefer ethernet interfaces
-		if strings.HasPrefix(iface.Name, "eth") || strings.HasPrefix(iface.Name, "en") {
-			addrs, err := iface.Addrs()
-			if err != nil {
-				continue
-			}
-			// Check if it has an IPv4 address
-			for _, addr := range addrs {
-				if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
-					return iface.Name
-				}
-			}
+		labelValues := connectionLabelValues(conn, "tcp", direction)
+		sockets.add(1, labelValues...)
+		if addQueues {
+			queues.add(conn, labelValues)
 		}
+
+		if c.tcpInfo != nil && conn.tcpInfo != nil && conn.state == "ESTABLISHED" {
+			c.tcpInfo.collect(ch, conn.tcpInfo, labelValues)
+		}
+	}
+
+	// The kernel only counts accept queue overflows per namespace, not per listener
+	if stats, err := readNetstat(ns.procNetFile("netstat")); err != nil {
+		log.Printf("Error reading %s: %v", ns.procNetFile("netstat"), err)
+		countError("netstat", "read")
+	} else if tcpExt, ok := stats["TcpExt"]; ok {
+		ch <- prometheus.MustNewConstMetric(c.listenOverflows, prometheus.CounterValue, tcpExt["ListenOverflows"], ns.label())
+		ch <- prometheus.MustNewConstMetric(c.listenDrops, prometheus.CounterValue, tcpExt["ListenDrops"], ns.label())
 	}
 
-	// Fallback: return first up interface with IPv4 (excluding loopback)
-	for _, iface := range interfaces {
-		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+	// Collect UDP sockets; bound unconnected sockets are listeners, connected ones are
+	// incoming when they share a listener's address and port (e.g. QUIC servers)
+	for _, conn := range udpConnections {
+		direction := connectionDirection(&conn, "udp", listeners)
+		aggregator.add(conn, "udp", direction)
+		addSocketOwner(owners, conn, socketProcesses)
+		if !c.opts.perSocket {
+			if queues != nil {
+				queues.add(conn, peerLabelValues(conn, "udp", direction))
+			}
 			continue
 		}
-		
-		addrs, err := iface.Addrs()
-		if err != nil {
-			continue
+		if c.opts.ephemeral != nil {
+			c.opts.ephemeral.collapse(&conn, "udp")
 		}
-		
-		for _, addr := range addrs {
-			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
-				return iface.Name
-			}
+		labelValues := connectionLabelValues(conn, "udp", direction)
+		sockets.add(1, labelValues...)
+		if queues != nil {
+			queues.add(conn, labelValues)
 		}
 	}
 
-	return "unknown"
-}
-
-const (
-	namespace = "network"
-	subsystem = "connections"
-)
-
-type networkConnectionsCollector struct {
-	metric *prometheus.Desc
-}
-
-func newNetworkConnectionsCollector() *networkConnectionsCollector {
-	 return &networkConnectionsCollector{
-	  metric: prometheus.NewDesc(
-	   "network_connections_info",
-	   "Information about network connections",
-	   []string{"source_address", "source_port", "destination_address", "destination_port", "state", "interface", "protocol", "direction", "process_name"},
-	   nil,
-	  ),
-	 }
-}
-
-func (c *networkConnectionsCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- c.metric
+	return tracked
 }
 
-func (c *networkConnectionsCollector) Collect(ch chan<- prometheus.Metric) {
-	// Build set of LISTEN ports for direction classification
-	listenPorts := make(map[string]struct{})
-	tcpConnectionsRaw, err := getTCPConnections("/proc/net/tcp", nil)
-	if err == nil {
-		for _, conn := range tcpConnectionsRaw {
-			if conn.state == "LISTEN" {
-				listenPorts[conn.sourcePort] = struct{}{}
-			}
-		}
+// addSocketOwner records the process owning an exported socket, if any
+func addSocketOwner(owners map[int]processInfo, conn tcpConnection, socketProcesses map[uint64]processInfo) {
+	if conn.pid == 0 {
+		return
 	}
-
-	// Collect TCP connections with direction label
-	tcpConnections, err := getTCPConnections("/proc/net/tcp", listenPorts)
-	if err != nil {
-		log.Printf("Error getting TCP connections: %v", err)
-	} else {
-		for _, conn := range tcpConnections {
-			direction := "outgoing"
-			if _, ok := listenPorts[conn.sourcePort]; ok {
-				direction = "incoming"
-			}
-			ch <- prometheus.MustNewConstMetric(c.metric, prometheus.GaugeValue, 1, conn.sourceAddress, conn.sourcePort, conn.destinationAddress, conn.destinationPort, conn.state, conn.sourceInterface, "tcp", direction, conn.processName)
-		}
+	if proc, ok := socketProcesses[conn.inode]; ok {
+		owners[proc.pid] = proc
 	}
+}
 
-	// Collect UDP sockets (no direction logic for now)
-	udpConnections, err := getUDPConnections("/proc/net/udp")
-	if err != nil {
-		log.Printf("Error getting UDP connections: %v", err)
-	} else {
-		 for _, conn := range udpConnections {
-		  ch <- prometheus.MustNewConstMetric(c.metric, prometheus.GaugeValue, 1, conn.sourceAddress, conn.sourcePort, conn.destinationAddress, conn.destinationPort, conn.state, conn.sourceInterface, "udp", "unknown", "")
-		 }
+// connectionLabelValues returns the values for connectionLabels, in order
+func connectionLabelValues(conn tcpConnection, protocol, direction string) []string {
+	pid := ""
+	if conn.pid > 0 {
+		pid = strconv.Itoa(conn.pid)
 	}
+	return []string{conn.sourceAddress, conn.sourcePort, conn.destinationAddress, conn.destinationPort, conn.state, conn.sourceInterface, conn.vrf, protocol, direction, conn.processName, conn.ipFamily, pid, conn.processExe, conn.netns, conn.netnsName}
 }
 
 type tcpConnection struct {
@@ -692,66 +387,48 @@ type tcpConnection struct {
 	destinationPort    string
 	state              string
 	sourceInterface    string
-	 processName       string
+	vrf                string // VRF device the socket belongs to, "" for the default VRF
+	boundIfIndex       uint32 // device the socket is bound to; only filled by the netlink backend
+	accepted           bool   // local end belongs to a listener; set by connectionDirection
+	rxQueue            uint64 // bytes not yet read by the application; accept queue length of LISTEN sockets
+	txQueue            uint64 // bytes not yet sent or acknowledged
+	listenBacklog      uint64 // accept queue capacity of LISTEN sockets; only filled by the netlink backend
+	processName        string
+	pid                int
+	processExe         string
+	ipFamily           string
+	inode              uint64
+	netns              string   // namespace inode
+	netnsName          string   // name under /run/netns, if any
+	tcpInfo            *tcpInfo // only filled by the netlink backend with --collector.tcp-info
+}
+
+// annotateConnections fills in the namespace, interface and owning process of sockets returned by a socketSource
+func annotateConnections(connections []tcpConnection, ns netNamespace, socketProcesses map[uint64]processInfo, resolveInterface func(conn tcpConnection) (iface, vrf string)) {
+	for i := range connections {
+		conn := &connections[i]
+		conn.netns = ns.label()
+		conn.netnsName = ns.name
+
+		// Sockets without an inode (e.g. TIME_WAIT) no longer belong to a process
+		if proc, ok := socketProcesses[conn.inode]; ok && conn.inode != 0 {
+			conn.pid = proc.pid
+			conn.processName = proc.name
+			conn.processExe = proc.exe
+		}
+
+		conn.sourceInterface, conn.vrf = resolveInterface(*conn)
+	}
 }
 
-func getTCPConnections(file string, listenPorts map[string]struct{}) ([]tcpConnection, error) {
+// getTCPConnections parses TCP sockets from /proc/net/tcp or /proc/net/tcp6
+func getTCPConnections(file string) ([]tcpConnection, error) {
 	f, err := os.Open(file)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	// Build a map of (localIP, localPort) to process name using ss -tup
-	cmd := exec.Command("ss", "-tulnp")
-	output, err := cmd.Output()
-	listenProcMap := make(map[string]string) // port -> process name
-	if err == nil {
-		scanner := bufio.NewScanner(strings.NewReader(string(output)))
-		for scanner.Scan() {
-			line := scanner.Text()
-			fields := strings.Fields(line)
-			if len(fields) < 6 {
-				continue
-			}
-			state := fields[1]
-			local := fields[4]
-			procInfo := fields[len(fields)-1]
-			// Only consider LISTEN sockets
-			if state == "LISTEN" && strings.Contains(procInfo, "users:(") {
-				start := strings.Index(procInfo, "(")
-				end := strings.Index(procInfo, ")")
-				if start != -1 && end != -1 && end > start {
-					procDetails := procInfo[start+1 : end]
-					procName := strings.Split(procDetails, ",")[0]
-					procName = strings.Trim(procName, "()[]{} ") // Remove brackets, parentheses, spaces
-					procName = strings.ReplaceAll(procName, "\"", "") // Remove all quotes
-					// Extract port
-					port := ""
-					if strings.HasPrefix(local, "[") {
-						// IPv6 [::]:PORT
-						idx := strings.LastIndex(local, ":")
-						if idx != -1 {
-							port = local[idx+1:]
-						}
-					} else if strings.HasPrefix(local, "*:") {
-						port = strings.Split(local, ":")[1]
-					} else {
-						parts := strings.Split(local, ":")
-						if len(parts) == 2 {
-							port = parts[1]
-						} else if len(parts) > 2 {
-							port = parts[len(parts)-1]
-						}
-					}
-					if port != "" {
-						listenProcMap[port] = procName
-					}
-				}
-			}
-		}
-	}
-
 	var connections []tcpConnection
 	scanner := bufio.NewScanner(f)
 	scanner.Scan() // Skip header line
@@ -766,41 +443,41 @@ func getTCPConnections(file string, listenPorts map[string]struct{}) ([]tcpConne
 		localAddress := fields[1]
 		remoteAddress := fields[2]
 		state := fields[3]
+		// For LISTEN sockets rx_queue is the accept queue length; the backlog is not shown
+		txQueue, rxQueue := parseQueues(fields[4])
+		inode, _ := strconv.ParseUint(fields[9], 10, 64)
 
 		sourceAddress, sourcePort, err := parseAddress(localAddress)
 		if err != nil {
 			log.Printf("Error parsing local address: %v", err)
+			countError("tcp", "parse")
 			continue
 		}
 
 		destinationAddress, destinationPort, err := parseAddress(remoteAddress)
 		if err != nil {
 			log.Printf("Error parsing remote address: %v", err)
+			countError("tcp", "parse")
 			continue
 		}
 
-		// Assign process name for LISTEN and ESTABLISHED incoming connections
-		processName := ""
-		isListen := connectionState(state) == "LISTEN"
-		isEstablishedIncoming := connectionState(state) == "ESTABLISHED" && listenPorts != nil && directionForEstablishedIncoming(sourcePort, listenPorts)
-		if isListen || isEstablishedIncoming {
-			if name, ok := listenProcMap[sourcePort]; ok {
-				processName = name
-			}
-		}
-
-
 		connections = append(connections, tcpConnection{
 			sourceAddress:      sourceAddress,
 			sourcePort:         sourcePort,
 			destinationAddress: destinationAddress,
 			destinationPort:    destinationPort,
 			state:              connectionState(state),
-			sourceInterface:    getInterfaceForConnection(sourceAddress, destinationAddress),
-			processName:        processName,
+			ipFamily:           ipFamily(sourceAddress),
+			inode:              inode,
+			rxQueue:            rxQueue,
+			txQueue:            txQueue,
 		})
 	}
 
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
 	return connections, nil
 }
 
@@ -829,9 +506,9 @@ func parseAddress(addr string) (string, string, error) {
 		reversedBytes[2] = ipBytes[1]
 		reversedBytes[3] = ipBytes[0]
 		ip = net.IP(reversedBytes).To4()
-	} else if len(ipBytes) == 16 { // IPv6 - commented out for future use
-		/*
-		// For IPv6, reverse each 4-byte segment
+	} else if len(ipBytes) == 16 { // IPv6
+		// The kernel prints IPv6 addresses as four host-order 32-bit words,
+		// so reverse the bytes within each 4-byte segment
 		reversedBytes := make([]byte, 16)
 		for i := 0; i < 4; i++ {
 			reversedBytes[i*4] = ipBytes[i*4+3]
@@ -839,9 +516,11 @@ func parseAddress(addr string) (string, string, error) {
 			reversedBytes[i*4+2] = ipBytes[i*4+1]
 			reversedBytes[i*4+3] = ipBytes[i*4]
 		}
-		ip = net.IP(reversedBytes).To16()
-		*/
-		return "", "", fmt.Errorf("IPv6 support is currently disabled")
+		ip = net.IP(reversedBytes)
+		// IPv4-mapped addresses (::ffff:a.b.c.d) on dual-stack sockets are reported in dotted form
+		if v4 := ip.To4(); v4 != nil {
+			ip = v4
+		}
 	} else {
 		return "", "", fmt.Errorf("invalid IP address length: %d", len(ipBytes))
 	}
@@ -849,6 +528,14 @@ func parseAddress(addr string) (string, string, error) {
 	return ip.String(), strconv.FormatInt(port, 10), nil
 }
 
+// parseQueues parses the "tx_queue:rx_queue" column of /proc/net/{tcp,udp}, two hex numbers
+func parseQueues(queues string) (tx, rx uint64) {
+	txHex, rxHex, _ := strings.Cut(queues, ":")
+	tx, _ = strconv.ParseUint(txHex, 16, 64)
+	rx, _ = strconv.ParseUint(rxHex, 16, 64)
+	return tx, rx
+}
+
 func connectionState(s string) string {
 	switch s {
 	case "01":
@@ -878,7 +565,16 @@ func connectionState(s string) string {
 	}
 }
 
-// getUDPConnections parses UDP sockets from /proc/net/udp
+// udpState derives a pseudo-state for a UDP socket. UDP sockets don't have traditional states,
+// so we use "LISTEN" for bound sockets (local port is not 0) and "UNCONN" for unbound ones
+func udpState(sourcePort string) string {
+	if sourcePort != "0" {
+		return "LISTEN"
+	}
+	return "UNCONN"
+}
+
+// getUDPConnections parses UDP sockets from /proc/net/udp or /proc/net/udp6
 func getUDPConnections(file string) ([]tcpConnection, error) {
 	f, err := os.Open(file)
 	if err != nil {
@@ -900,38 +596,33 @@ func getUDPConnections(file string) ([]tcpConnection, error) {
 
 		localAddress := fields[1]
 		remoteAddress := fields[2]
-		// UDP sockets don't have traditional states, but we can use the socket state
-		// For UDP, we'll use "LISTEN" for bound sockets and "UNCONN" for unconnected
-		state := "UNCONN" // Default for UDP
-		
-		// Check if it's a bound UDP socket (local port is not 0)
+		txQueue, rxQueue := parseQueues(fields[4])
+		inode, _ := strconv.ParseUint(fields[9], 10, 64)
+
 		sourceAddress, sourcePort, err := parseAddress(localAddress)
 		if err != nil {
 			log.Printf("Error parsing local address: %v", err)
+			countError("udp", "parse")
 			continue
 		}
-		
-		// For UDP, if there's a local address bound, consider it "listening"
-		if sourcePort != "0" {
-			state = "LISTEN"
-		}
 
 		destinationAddress, destinationPort, err := parseAddress(remoteAddress)
 		if err != nil {
 			log.Printf("Error parsing remote address: %v", err)
+			countError("udp", "parse")
 			continue
 		}
 
-		// Get network interface for source IP (use same logic as TCP connections)
-		sourceInterface := getInterfaceForConnection(sourceAddress, destinationAddress)
-
 		connections = append(connections, tcpConnection{
 			sourceAddress:      sourceAddress,
 			sourcePort:         sourcePort,
 			destinationAddress: destinationAddress,
 			destinationPort:    destinationPort,
-			state:              state,
-			sourceInterface:    sourceInterface,
+			state:              udpState(sourcePort),
+			ipFamily:           ipFamily(sourceAddress),
+			inode:              inode,
+			rxQueue:            rxQueue,
+			txQueue:            txQueue,
 		})
 	}
 
@@ -943,15 +634,99 @@ func getUDPConnections(file string) ([]tcpConnection, error) {
 }
 
 func main() {
-	collector := newNetworkConnectionsCollector()
-	prometheus.MustRegister(collector)
+	flag.StringVar(&procfsRoot, "path.procfs", "/proc", "procfs mount point (e.g. /host/proc when the host's /proc is mounted into a container)")
+	flag.StringVar(&sysfsRoot, "path.sysfs", "/sys", "sysfs mount point (e.g. /host/sys when the host's /sys is mounted into a container)")
+	backend := flag.String("collector.backend", "proc", "Socket collection backend: \"proc\" (parse /proc/net/*) or \"netlink\" (NETLINK_INET_DIAG)")
+	tcpStatesFlag := flag.String("collector.tcp-states", "", "Comma-separated TCP states to export (e.g. ESTABLISHED,LISTEN); empty exports all states")
+	withTCPInfo := flag.Bool("collector.tcp-info", false, "Export per-connection TCP_INFO metrics (RTT, cwnd, retransmits, ...) for ESTABLISHED sockets; requires the netlink backend")
+	collapseEphemeral := flag.Bool("collector.collapse-ephemeral-ports", false, "Replace the ephemeral port of outgoing/incoming connections with \"ephemeral\" and sum the resulting duplicate series")
+	mode := flag.String("collector.mode", "full", "\"full\" exports one series per socket plus aggregated counts, \"aggregate\" exports only the aggregated counts")
+	allNetns := flag.Bool("collector.all-netns", false, "Collect sockets from every network namespace on the host (containers, ip netns); requires root")
+	criEndpoint := flag.String("collector.cri-endpoint", "", "CRI runtime socket used to resolve container IDs to Kubernetes pods (e.g. unix:///run/containerd/containerd.sock); empty disables pod lookup")
+	lifecycle := flag.Bool("collector.lifecycle", false, "Track TCP connections across scrapes and export network_connections_opened_total/closed_total")
+	interval := flag.Duration("collector.interval", 0, "Collect in the background at this interval (e.g. 15s) and serve the cached snapshot on every scrape; 0 collects synchronously on each scrape")
+	bonding := flag.Bool("collector.bonding", true, "Export bonding interface health from /proc/net/bonding (mode, active slave, slave MII status, link failures, speed)")
+	socketQueues := flag.Bool("collector.socket-queues", false, "Export the receive and send queue bytes of TCP and UDP sockets with data queued")
+	socketQueuesMin := flag.Uint64("collector.socket-queues-min-bytes", 0, "Only export socket queues of sockets with more than this many bytes in their receive or send queue; the default 0 leaves out sockets with both queues empty")
+	interfaceInfo := flag.Bool("collector.interface-info", true, "Export interface metadata joinable on the interface label (master device, VLAN ID, MTU, speed, address labels and secondary flags)")
+	protocolStats := flag.Bool("collector.protocol-stats", true, "Export host-wide IP, TCP and UDP counters from /proc/net/snmp, snmp6, netstat and sockstat (opens, retransmits, resets, buffer errors, TIME_WAIT, socket memory)")
+	conntrack := flag.Bool("collector.conntrack", false, "Export the netfilter conntrack table usage against nf_conntrack_max and its entries counted by protocol, state, NAT translation and zone, including forwarded and NATed flows")
+	conntrackFlows := flag.Bool("collector.conntrack-flows", false, "Also export one series per conntrack entry with both tuples, mark and accounting counters; requires --collector.conntrack")
+	flag.Parse()
+
+	tcpStates, err := parseTCPStates(*tcpStatesFlag)
+	if err != nil {
+		log.Fatalf("Invalid --collector.tcp-states: %v", err)
+	}
+
+	source := newSocketSource(*backend, *withTCPInfo)
+	if _, ok := source.(*netlinkSocketSource); !ok && *withTCPInfo {
+		log.Printf("Warning: --collector.tcp-info requires the netlink backend, TCP_INFO metrics disabled")
+		*withTCPInfo = false
+	}
+
+	opts := collectorOptions{
+		tcpStates:   tcpStates,
+		withTCPInfo: *withTCPInfo,
+		allNetns:    *allNetns,
+		perSocket:   true,
+		lifecycle:   *lifecycle,
+		queues:      *socketQueues,
+		queueMin:    *socketQueuesMin,
+	}
+	switch *mode {
+	case "full":
+	case "aggregate":
+		opts.perSocket = false
+		if opts.withTCPInfo {
+			log.Printf("Warning: --collector.tcp-info exports per-socket series and is disabled in aggregate mode")
+		}
+	default:
+		log.Fatalf("Invalid --collector.mode %q: must be \"full\" or \"aggregate\"", *mode)
+	}
+	if *collapseEphemeral {
+		opts.ephemeral = readLocalPortRange()
+		if opts.withTCPInfo {
+			log.Printf("Warning: per-connection TCP_INFO metrics cannot be summed and are disabled with --collector.collapse-ephemeral-ports")
+		}
+	}
+	if *criEndpoint != "" {
+		opts.cri = newCRIClient(*criEndpoint)
+	}
+
+	registerSelfMetrics()
+	ownRoutes.monitor()
+	localInterfaces.monitor()
+	collector := newNetworkConnectionsCollector(source, opts)
+	if *interval > 0 {
+		snapshot := newSnapshotCollector(collector, *interval)
+		snapshot.start()
+		prometheus.MustRegister(snapshot)
+	} else {
+		prometheus.MustRegister(collector)
+	}
+
+	if *bonding {
+		prometheus.MustRegister(newBondingCollector())
+	}
+	if *interfaceInfo {
+		prometheus.MustRegister(newInterfaceCollector())
+	}
+	if *protocolStats {
+		prometheus.MustRegister(newProtocolCollector())
+	}
+	if *conntrack {
+		prometheus.MustRegister(newConntrackCollector(*conntrackFlows))
+	} else if *conntrackFlows {
+		log.Printf("Warning: --collector.conntrack-flows requires --collector.conntrack, conntrack metrics disabled")
+	}
 
 	// Get port from environment variable or use default
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "9100"
 	}
-	
+
 	http.Handle("/metrics", promhttp.Handler())
 	log.Printf("Beginning to serve on port :%s", port)
 	log.Fatal(http.ListenAndServe(":"+port, nil))
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		ip      string
+		port    string
+		wantErr bool
+	}{
+		{name: "ipv4", addr: "0100007F:0050", ip: "127.0.0.1", port: "80"},
+		{name: "ipv4 wildcard", addr: "00000000:1F90", ip: "0.0.0.0", port: "8080"},
+		{name: "ipv6 loopback", addr: "00000000000000000000000001000000:0016", ip: "::1", port: "22"},
+		{name: "ipv6 word order", addr: "B80D0120000000000000000001000000:01BB", ip: "2001:db8::1", port: "443"},
+		{name: "ipv6 wildcard", addr: "00000000000000000000000000000000:0000", ip: "::", port: "0"},
+		{name: "ipv4-mapped", addr: "0000000000000000FFFF00000A01A8C0:1F90", ip: "192.168.1.10", port: "8080"},
+		{name: "missing port", addr: "0100007F", wantErr: true},
+		{name: "invalid hex", addr: "0100007G:0050", wantErr: true},
+		{name: "invalid length", addr: "01007F:0050", wantErr: true},
+		{name: "invalid port", addr: "0100007F:ZZ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, port, err := parseAddress(tt.addr)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseAddress(%q) = %q, %q, want error", tt.addr, ip, port)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAddress(%q): %v", tt.addr, err)
+			}
+			if ip != tt.ip || port != tt.port {
+				t.Errorf("parseAddress(%q) = %q, %q, want %q, %q", tt.addr, ip, port, tt.ip, tt.port)
+			}
+		})
+	}
+}
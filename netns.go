@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// netNamespace is a network namespace sockets are collected from
+type netNamespace struct {
+	inode uint64
+	name  string // name under /run/netns, empty if unnamed
+	pid   int    // representative process in the namespace, 0 for the exporter's own namespace
+	path  string // namespace file usable with setns(2)
+}
+
+// own reports whether this is the exporter's own network namespace
+func (ns netNamespace) own() bool {
+	return ns.pid == 0
+}
+
+// procNetFile returns the /proc path of a socket table (e.g. "tcp6") as seen from inside the namespace
+func (ns netNamespace) procNetFile(file string) string {
+	if ns.own() {
+		return procFilePath("net", file)
+	}
+	return procFilePath(strconv.Itoa(ns.pid), "net", file)
+}
+
+// label returns the namespace identifier used in the netns label
+func (ns netNamespace) label() string {
+	if ns.inode == 0 {
+		return ""
+	}
+	return strconv.FormatUint(ns.inode, 10)
+}
+
+// netnsInode returns the inode of a namespace file, which uniquely identifies the namespace
+func netnsInode(path string) (uint64, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Ino, nil
+}
+
+// currentNetNamespace returns the exporter's own network namespace. This always uses
+// the exporter's /proc rather than --path.procfs since it identifies our own process.
+func currentNetNamespace() netNamespace {
+	ns := netNamespace{path: "/proc/self/ns/net"}
+	inode, err := netnsInode(ns.path)
+	if err != nil {
+		log.Printf("Warning: Could not determine own network namespace: %v", err)
+		return ns
+	}
+	ns.inode = inode
+	ns.name = namedNetNamespaces()[inode]
+	return ns
+}
+
+// namedNetNamespaces maps namespace inodes to the names created with "ip netns add"
+func namedNetNamespaces() map[uint64]string {
+	names := make(map[uint64]string)
+	entries, err := os.ReadDir("/run/netns")
+	if err != nil {
+		return names
+	}
+	for _, entry := range entries {
+		if inode, err := netnsInode(filepath.Join("/run/netns", entry.Name())); err == nil {
+			names[inode] = entry.Name()
+		}
+	}
+	return names
+}
+
+// discoverNetNamespaces finds every distinct network namespace by walking /proc/*/ns/net.
+// The lowest PID in each namespace is used to read its /proc/<pid>/net tables.
+// Named namespaces without any process are skipped since they hold no sockets of interest.
+func discoverNetNamespaces() []netNamespace {
+	own := currentNetNamespace()
+	names := namedNetNamespaces()
+
+	byInode := map[uint64]netNamespace{own.inode: own}
+	entries, err := os.ReadDir(procfsRoot)
+	if err != nil {
+		log.Printf("Warning: Could not list %s for network namespaces: %v", procfsRoot, err)
+		countError("netns", "list")
+		return []netNamespace{own}
+	}
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		path := procFilePath(entry.Name(), "ns", "net")
+		inode, err := netnsInode(path)
+		if err != nil {
+			// Process exited or we lack permission (needs root or CAP_SYS_PTRACE)
+			continue
+		}
+		if existing, ok := byInode[inode]; ok && (existing.own() || existing.pid < pid) {
+			continue
+		}
+		byInode[inode] = netNamespace{inode: inode, name: names[inode], pid: pid, path: path}
+	}
+
+	namespaces := make([]netNamespace, 0, len(byInode))
+	for _, ns := range byInode {
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces
+}
+
+// inNetNamespace runs fn on a thread switched into ns. It is used to create netlink
+// sockets inside another namespace; the sockets stay bound to that namespace after
+// the thread switches back.
+func inNetNamespace(ns netNamespace, fn func() error) error {
+	if ns.own() {
+		return fn()
+	}
+
+	// Switch on a dedicated goroutine: if the thread cannot be switched back it stays
+	// locked, and the runtime terminates it when the goroutine exits instead of handing
+	// it to the caller or another goroutine
+	errc := make(chan error, 1)
+	go func() {
+		errc <- switchNetNamespace(ns, fn)
+	}()
+	return <-errc
+}
+
+// switchNetNamespace locks the calling goroutine to its thread, runs fn in ns and
+// switches back, unlocking the thread only once it is in its original namespace again
+func switchNetNamespace(ns netNamespace, fn func() error) error {
+	runtime.LockOSThread()
+
+	origin, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer origin.Close()
+
+	target, err := os.Open(ns.path)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer target.Close()
+
+	if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("setns %s: %v", ns.path, err)
+	}
+
+	fnErr := fn()
+
+	if err := unix.Setns(int(origin.Fd()), unix.CLONE_NEWNET); err != nil {
+		// Leave the thread locked so it is discarded when the goroutine exits
+		return fmt.Errorf("restoring network namespace: %v", err)
+	}
+	runtime.UnlockOSThread()
+
+	return fnErr
+}
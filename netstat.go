@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readNetstat parses /proc/net/netstat (or /proc/net/snmp), where every protocol is
+// a header line of counter names followed by a line of values, both prefixed with
+// "Proto:". The result maps protocol and counter name to its value.
+func readNetstat(file string) (map[string]map[string]float64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats := make(map[string]map[string]float64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		names := strings.Fields(scanner.Text())
+		if !scanner.Scan() {
+			return nil, fmt.Errorf("%s: header without values", file)
+		}
+		values := strings.Fields(scanner.Text())
+		if len(names) == 0 || len(names) != len(values) || names[0] != values[0] {
+			return nil, fmt.Errorf("%s: mismatched header and values for %q", file, names)
+		}
+
+		proto := strings.TrimSuffix(names[0], ":")
+		if stats[proto] == nil {
+			stats[proto] = make(map[string]float64)
+		}
+		for i := 1; i < len(names); i++ {
+			// Values are unsigned, except the signed Tcp MaxConn
+			v, err := strconv.ParseFloat(values[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid value for %s %s: %v", file, proto, names[i], err)
+			}
+			stats[proto][names[i]] = v
+		}
+	}
+	return stats, scanner.Err()
+}
+
+// readSnmp6 parses /proc/net/snmp6, one "Ip6InReceives 5" line per counter, into the
+// layout of readNetstat: the name is split after the protocol's "6" (Ip6, Icmp6, Udp6, UdpLite6)
+func readSnmp6(file string) (map[string]map[string]float64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats := make(map[string]map[string]float64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		i := strings.Index(fields[0], "6")
+		if i < 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid value for %s: %v", file, fields[0], err)
+		}
+		proto, name := fields[0][:i+1], fields[0][i+1:]
+		if stats[proto] == nil {
+			stats[proto] = make(map[string]float64)
+		}
+		stats[proto][name] = v
+	}
+	return stats, scanner.Err()
+}
+
+// readSockstat parses /proc/net/sockstat (or sockstat6), where every line is a protocol
+// followed by name/value pairs, e.g. "TCP: inuse 4 orphan 0 tw 2 alloc 6 mem 1"
+func readSockstat(file string) (map[string]map[string]float64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats := make(map[string]map[string]float64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || len(fields)%2 != 1 {
+			continue
+		}
+		proto := strings.TrimSuffix(fields[0], ":")
+		stats[proto] = make(map[string]float64)
+		for i := 1; i < len(fields); i += 2 {
+			v, err := strconv.ParseFloat(fields[i+1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid value for %s %s: %v", file, proto, fields[i], err)
+			}
+			stats[proto][fields[i]] = v
+		}
+	}
+	return stats, scanner.Err()
+}
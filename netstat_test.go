@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadNetstat(t *testing.T) {
+	tests := []struct {
+		file string
+		want map[string]float64 // a sample of protocol.name counters
+	}{
+		{
+			file: "testdata/proc/net/snmp",
+			want: map[string]float64{
+				"Ip.InReceives":   2218470,
+				"Ip.OutNoRoutes":  2,
+				"Icmp.OutMsgs":    45,
+				"Tcp.MaxConn":     -1,
+				"Tcp.RetransSegs": 1844,
+				"Tcp.CurrEstab":   23,
+				"Udp.NoPorts":     41,
+				"Udp.MemErrors":   0,
+			},
+		},
+		{
+			file: "testdata/proc/net/netstat",
+			want: map[string]float64{
+				"TcpExt.SyncookiesSent": 4,
+				"TcpExt.TCPAbortOnData": 85,
+				"IpExt.InOctets":        1874232983,
+				"IpExt.OutOctets":       412312398,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(filepath.Base(tt.file), func(t *testing.T) {
+			stats, err := readNetstat(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStats(t, stats, tt.want)
+		})
+	}
+}
+
+func TestReadNetstatMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"header without values", "Tcp: ActiveOpens PassiveOpens\n"},
+		{"value count mismatch", "Tcp: ActiveOpens PassiveOpens\nTcp: 1\n"},
+		{"protocol mismatch", "Tcp: ActiveOpens\nUdp: 1\n"},
+		{"invalid value", "Tcp: ActiveOpens\nTcp: x\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "snmp")
+			if err := os.WriteFile(file, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if stats, err := readNetstat(file); err == nil {
+				t.Errorf("readNetstat() = %v, want error", stats)
+			}
+		})
+	}
+}
+
+func TestReadSnmp6(t *testing.T) {
+	stats, err := readSnmp6("testdata/proc/net/snmp6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, stats, map[string]float64{
+		"Ip6.InReceives":       50112,
+		"Ip6.OutNoRoutes":      5,
+		"Ip6.InOctets":         9841237,
+		"Icmp6.InType135":      17,
+		"Udp6.InDatagrams":     1204,
+		"Udp6.NoPorts":         3,
+		"UdpLite6.InDatagrams": 0,
+	})
+}
+
+func TestReadSockstat(t *testing.T) {
+	tests := []struct {
+		file string
+		want map[string]map[string]float64
+	}{
+		{
+			file: "testdata/proc/net/sockstat",
+			want: map[string]map[string]float64{
+				"sockets": {"used": 412},
+				"TCP":     {"inuse": 21, "orphan": 1, "tw": 34, "alloc": 29, "mem": 6},
+				"UDP":     {"inuse": 7, "mem": 3},
+				"UDPLITE": {"inuse": 0},
+				"RAW":     {"inuse": 1},
+				"FRAG":    {"inuse": 0, "memory": 0},
+			},
+		},
+		{
+			file: "testdata/proc/net/sockstat6",
+			want: map[string]map[string]float64{
+				"TCP6":     {"inuse": 6},
+				"UDP6":     {"inuse": 4},
+				"UDPLITE6": {"inuse": 0},
+				"RAW6":     {"inuse": 1},
+				"FRAG6":    {"inuse": 0, "memory": 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(filepath.Base(tt.file), func(t *testing.T) {
+			stats, err := readSockstat(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(stats, tt.want) {
+				t.Errorf("readSockstat() = %v, want %v", stats, tt.want)
+			}
+		})
+	}
+}
+
+// checkStats compares the "proto.name" counters in want against stats
+func checkStats(t *testing.T, stats map[string]map[string]float64, want map[string]float64) {
+	t.Helper()
+	for key, v := range want {
+		proto, name, _ := strings.Cut(key, ".")
+		got, ok := stats[proto][name]
+		if !ok {
+			t.Errorf("%s missing", key)
+		} else if got != v {
+			t.Errorf("%s = %v, want %v", key, got, v)
+		}
+	}
+}
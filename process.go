@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// processInfo identifies the process owning a socket
+type processInfo struct {
+	pid  int
+	name string // command name from /proc/<pid>/comm
+	exe  string // executable path from /proc/<pid>/exe, empty if not readable
+
+	cgroup      string // cgroup path from /proc/<pid>/cgroup
+	systemdUnit string // systemd unit derived from the cgroup path, empty if not under systemd
+	containerID string // container ID derived from the cgroup path, empty if not in a container
+}
+
+// getSocketProcessMap maps socket inodes to their owning process by walking the
+// socket:[inode] links in /proc/<pid>/fd. A socket shared between processes (e.g.
+// after fork) is attributed to the lowest PID, which is normally the parent.
+func getSocketProcessMap() map[uint64]processInfo {
+	socketProcesses := make(map[uint64]processInfo)
+
+	entries, err := os.ReadDir(procfsRoot)
+	if err != nil {
+		return socketProcesses
+	}
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		fdDir := procFilePath(entry.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			// Process exited or we lack permission (needs root or CAP_SYS_PTRACE)
+			continue
+		}
+
+		var proc *processInfo
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+			if existing, ok := socketProcesses[inode]; ok && existing.pid < pid {
+				continue
+			}
+			if proc == nil {
+				proc = readProcessInfo(pid)
+			}
+			socketProcesses[inode] = *proc
+		}
+	}
+
+	return socketProcesses
+}
+
+// readProcessInfo reads the command name and executable path of a process
+func readProcessInfo(pid int) *processInfo {
+	procDir := procFilePath(strconv.Itoa(pid))
+	proc := &processInfo{pid: pid}
+
+	if comm, err := os.ReadFile(filepath.Join(procDir, "comm")); err == nil {
+		proc.name = strings.TrimSpace(string(comm))
+	}
+	if exe, err := os.Readlink(filepath.Join(procDir, "exe")); err == nil {
+		proc.exe = strings.TrimSuffix(exe, " (deleted)")
+	}
+	if cgroup, err := os.ReadFile(filepath.Join(procDir, "cgroup")); err == nil {
+		proc.cgroup = parseCgroupPath(string(cgroup))
+		proc.systemdUnit = systemdUnitFromCgroup(proc.cgroup)
+		proc.containerID = containerIDFromCgroup(proc.cgroup)
+	}
+
+	return proc
+}
+
+// parseCgroupPath picks the cgroup path out of /proc/<pid>/cgroup. On the unified
+// hierarchy (cgroup v2) this is the "0::" line; on v1 the name=systemd hierarchy
+// is preferred since that is where systemd places units, then any other controller.
+func parseCgroupPath(content string) string {
+	var systemdPath, firstPath string
+	for _, line := range strings.Split(strings.TrimSpace(content), "\n") {
+		// Format: hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		switch {
+		case parts[0] == "0" && parts[1] == "":
+			return parts[2]
+		case parts[1] == "name=systemd":
+			systemdPath = parts[2]
+		case firstPath == "":
+			firstPath = parts[2]
+		}
+	}
+	if systemdPath != "" {
+		return systemdPath
+	}
+	return firstPath
+}
+
+// systemdUnitFromCgroup returns the innermost service or scope unit in a cgroup path,
+// e.g. "/system.slice/nginx.service" -> "nginx.service". Services that delegate their
+// cgroup (e.g. "/system.slice/foo.service/payload") still resolve to the service.
+func systemdUnitFromCgroup(cgroup string) string {
+	parts := strings.Split(cgroup, "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if strings.HasSuffix(parts[i], ".service") || strings.HasSuffix(parts[i], ".scope") {
+			return parts[i]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestParseCgroupPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "unified",
+			content: "0::/system.slice/nginx.service\n",
+			want:    "/system.slice/nginx.service",
+		},
+		{
+			name: "v1 prefers name=systemd",
+			content: "12:cpu,cpuacct:/user.slice\n" +
+				"11:memory:/user.slice\n" +
+				"1:name=systemd:/system.slice/sshd.service\n",
+			want: "/system.slice/sshd.service",
+		},
+		{
+			name: "hybrid prefers unified",
+			content: "1:name=systemd:/system.slice/cron.service\n" +
+				"0::/system.slice/cron.service\n",
+			want: "/system.slice/cron.service",
+		},
+		{
+			name:    "v1 without systemd",
+			content: "4:memory:/docker/abc\n3:cpu:/docker/abc\n",
+			want:    "/docker/abc",
+		},
+		{
+			name:    "empty",
+			content: "",
+			want:    "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseCgroupPath(tt.content); got != tt.want {
+				t.Errorf("parseCgroupPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSystemdUnitFromCgroup(t *testing.T) {
+	tests := []struct {
+		cgroup string
+		want   string
+	}{
+		{"/system.slice/nginx.service", "nginx.service"},
+		{"/system.slice/foo.service/payload", "foo.service"},
+		{"/user.slice/user-1000.slice/session-3.scope", "session-3.scope"},
+		{"/system.slice/docker-" + testContainerID + ".scope", "docker-" + testContainerID + ".scope"},
+		{"/kubepods/burstable/pod1234/" + testContainerID, ""},
+		{"/", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := systemdUnitFromCgroup(tt.cgroup); got != tt.want {
+			t.Errorf("systemdUnitFromCgroup(%q) = %q, want %q", tt.cgroup, got, tt.want)
+		}
+	}
+}
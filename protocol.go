@@ -0,0 +1,172 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// protocolSource is one kernel counter feeding a protocol metric, looked up by the
+// protocol and name used in /proc/net/snmp, snmp6, netstat or sockstat
+type protocolSource struct {
+	proto      string
+	name       string
+	labelValue string  // value of the metric's label, if it has one
+	scale      float64 // multiplier, 0 means 1
+}
+
+// protocolStat is one exported protocol metric
+type protocolStat struct {
+	name      string
+	help      string
+	valueType prometheus.ValueType
+	label     string // label telling the sources apart ("family", "reason"), empty for a single source
+	sources   []protocolSource
+}
+
+// bothFamilies reads a counter from the IPv4 protocol and its snmp6 counterpart
+func bothFamilies(proto4, name4, proto6, name6 string) []protocolSource {
+	return []protocolSource{
+		{proto: proto4, name: name4, labelValue: "ipv4"},
+		{proto: proto6, name: name6, labelValue: "ipv6"},
+	}
+}
+
+// protocolStats is the curated set of host-wide counters most useful when troubleshooting
+// connections. TCP counters in snmp cover both address families; UDP and IP are split.
+var protocolStats = func() []protocolStat {
+	pageSize := float64(os.Getpagesize())
+	counter, gauge := prometheus.CounterValue, prometheus.GaugeValue
+	return []protocolStat{
+		{"network_ip_packets_received_total", "IP packets received from interfaces, including errors", counter, "family", bothFamilies("Ip", "InReceives", "Ip6", "InReceives")},
+		{"network_ip_packets_delivered_total", "IP packets delivered to transport protocols", counter, "family", bothFamilies("Ip", "InDelivers", "Ip6", "InDelivers")},
+		{"network_ip_packets_sent_total", "IP packets handed to the IP layer for transmission by local protocols", counter, "family", bothFamilies("Ip", "OutRequests", "Ip6", "OutRequests")},
+		{"network_ip_packets_forwarded_total", "IP packets forwarded to another host", counter, "family", bothFamilies("Ip", "ForwDatagrams", "Ip6", "OutForwDatagrams")},
+		{"network_ip_receive_discards_total", "Received IP packets discarded without error, e.g. for lack of buffer space", counter, "family", bothFamilies("Ip", "InDiscards", "Ip6", "InDiscards")},
+		{"network_ip_send_discards_total", "Outgoing IP packets discarded without error, e.g. for lack of buffer space", counter, "family", bothFamilies("Ip", "OutDiscards", "Ip6", "OutDiscards")},
+		{"network_ip_no_route_total", "Outgoing IP packets discarded because no route was found", counter, "family", bothFamilies("Ip", "OutNoRoutes", "Ip6", "OutNoRoutes")},
+		{"network_ip_header_errors_total", "Received IP packets discarded for header errors", counter, "family", bothFamilies("Ip", "InHdrErrors", "Ip6", "InHdrErrors")},
+		{"network_ip_address_errors_total", "Received IP packets discarded for an invalid destination address", counter, "family", bothFamilies("Ip", "InAddrErrors", "Ip6", "InAddrErrors")},
+		{"network_ip_received_bytes_total", "Bytes received in IP packets", counter, "family", bothFamilies("IpExt", "InOctets", "Ip6", "InOctets")},
+		{"network_ip_sent_bytes_total", "Bytes sent in IP packets", counter, "family", bothFamilies("IpExt", "OutOctets", "Ip6", "OutOctets")},
+
+		{"network_tcp_active_opens_total", "TCP connections opened by this host (SYN sent)", counter, "", []protocolSource{{proto: "Tcp", name: "ActiveOpens"}}},
+		{"network_tcp_passive_opens_total", "TCP connections accepted by this host (SYN received)", counter, "", []protocolSource{{proto: "Tcp", name: "PassiveOpens"}}},
+		{"network_tcp_attempt_fails_total", "TCP connection attempts that failed before reaching ESTABLISHED", counter, "", []protocolSource{{proto: "Tcp", name: "AttemptFails"}}},
+		{"network_tcp_established_resets_total", "TCP connections reset from ESTABLISHED or CLOSE_WAIT", counter, "", []protocolSource{{proto: "Tcp", name: "EstabResets"}}},
+		{"network_tcp_established", "TCP connections currently in ESTABLISHED or CLOSE_WAIT", gauge, "", []protocolSource{{proto: "Tcp", name: "CurrEstab"}}},
+		{"network_tcp_segments_received_total", "TCP segments received, including errors", counter, "", []protocolSource{{proto: "Tcp", name: "InSegs"}}},
+		{"network_tcp_segments_sent_total", "TCP segments sent, excluding retransmissions", counter, "", []protocolSource{{proto: "Tcp", name: "OutSegs"}}},
+		{"network_tcp_segments_retransmitted_total", "TCP segments retransmitted", counter, "", []protocolSource{{proto: "Tcp", name: "RetransSegs"}}},
+		{"network_tcp_receive_errors_total", "TCP segments received in error, e.g. with a bad checksum", counter, "", []protocolSource{{proto: "Tcp", name: "InErrs"}}},
+		{"network_tcp_checksum_errors_total", "TCP segments received with a bad checksum", counter, "", []protocolSource{{proto: "Tcp", name: "InCsumErrors"}}},
+		{"network_tcp_resets_sent_total", "TCP segments sent with the RST flag", counter, "", []protocolSource{{proto: "Tcp", name: "OutRsts"}}},
+		{"network_tcp_timeouts_total", "TCP retransmission timer expirations", counter, "", []protocolSource{{proto: "TcpExt", name: "TCPTimeouts"}}},
+		{"network_tcp_syn_retransmits_total", "TCP SYN and SYN-ACK retransmissions", counter, "", []protocolSource{{proto: "TcpExt", name: "TCPSynRetrans"}}},
+		{"network_tcp_aborts_total", "TCP connections aborted by the kernel, by reason", counter, "reason", []protocolSource{
+			{proto: "TcpExt", name: "TCPAbortOnData", labelValue: "data"},
+			{proto: "TcpExt", name: "TCPAbortOnClose", labelValue: "close"},
+			{proto: "TcpExt", name: "TCPAbortOnMemory", labelValue: "memory"},
+			{proto: "TcpExt", name: "TCPAbortOnTimeout", labelValue: "timeout"},
+			{proto: "TcpExt", name: "TCPAbortOnLinger", labelValue: "linger"},
+			{proto: "TcpExt", name: "TCPAbortFailed", labelValue: "failed"},
+		}},
+		{"network_tcp_syncookies_sent_total", "TCP SYN cookies sent because a listener's SYN queue overflowed", counter, "", []protocolSource{{proto: "TcpExt", name: "SyncookiesSent"}}},
+		{"network_tcp_syncookies_received_total", "Valid TCP SYN cookies received", counter, "", []protocolSource{{proto: "TcpExt", name: "SyncookiesRecv"}}},
+		{"network_tcp_syncookies_failed_total", "Invalid TCP SYN cookies received", counter, "", []protocolSource{{proto: "TcpExt", name: "SyncookiesFailed"}}},
+		{"network_tcp_backlog_drops_total", "TCP segments dropped because the socket backlog was full", counter, "", []protocolSource{{proto: "TcpExt", name: "TCPBacklogDrop"}}},
+		{"network_tcp_memory_pressures_total", "Times TCP entered memory pressure (tcp_mem)", counter, "", []protocolSource{{proto: "TcpExt", name: "TCPMemoryPressures"}}},
+		{"network_tcp_sockets_in_use", "TCP sockets in use, excluding TIME_WAIT", gauge, "family", bothFamilies("TCP", "inuse", "TCP6", "inuse")},
+		{"network_tcp_orphan_sockets", "TCP sockets no longer attached to a file descriptor, e.g. closed while data was still queued", gauge, "", []protocolSource{{proto: "TCP", name: "orphan"}}},
+		{"network_tcp_timewait_sockets", "TCP sockets in TIME_WAIT", gauge, "", []protocolSource{{proto: "TCP", name: "tw"}}},
+		{"network_tcp_allocated_sockets", "TCP sockets allocated, including TIME_WAIT and orphans", gauge, "", []protocolSource{{proto: "TCP", name: "alloc"}}},
+		{"network_tcp_memory_bytes", "Memory used by TCP socket buffers, compared against tcp_mem", gauge, "", []protocolSource{{proto: "TCP", name: "mem", scale: pageSize}}},
+
+		{"network_udp_datagrams_received_total", "UDP datagrams delivered to sockets", counter, "family", bothFamilies("Udp", "InDatagrams", "Udp6", "InDatagrams")},
+		{"network_udp_datagrams_sent_total", "UDP datagrams sent", counter, "family", bothFamilies("Udp", "OutDatagrams", "Udp6", "OutDatagrams")},
+		{"network_udp_no_port_total", "UDP datagrams received for a port without a socket", counter, "family", bothFamilies("Udp", "NoPorts", "Udp6", "NoPorts")},
+		{"network_udp_receive_errors_total", "UDP datagrams that could not be delivered, including buffer and checksum errors", counter, "family", bothFamilies("Udp", "InErrors", "Udp6", "InErrors")},
+		{"network_udp_receive_buffer_errors_total", "UDP datagrams dropped because the socket receive buffer was full", counter, "family", bothFamilies("Udp", "RcvbufErrors", "Udp6", "RcvbufErrors")},
+		{"network_udp_send_buffer_errors_total", "UDP datagrams dropped because the socket send buffer was full", counter, "family", bothFamilies("Udp", "SndbufErrors", "Udp6", "SndbufErrors")},
+		{"network_udp_checksum_errors_total", "UDP datagrams received with a bad checksum", counter, "family", bothFamilies("Udp", "InCsumErrors", "Udp6", "InCsumErrors")},
+		{"network_udp_sockets_in_use", "UDP sockets in use", gauge, "family", bothFamilies("UDP", "inuse", "UDP6", "inuse")},
+		{"network_udp_memory_bytes", "Memory used by UDP socket buffers, compared against udp_mem", gauge, "", []protocolSource{{proto: "UDP", name: "mem", scale: pageSize}}},
+
+		{"network_sockets_used", "Sockets in use across all protocols", gauge, "", []protocolSource{{proto: "sockets", name: "used"}}},
+	}
+}()
+
+// protocolFiles are the /proc/net files protocolStats are read from, with their parser
+var protocolFiles = []struct {
+	name  string
+	parse func(string) (map[string]map[string]float64, error)
+}{
+	{"snmp", readNetstat},
+	{"snmp6", readSnmp6},
+	{"netstat", readNetstat},
+	{"sockstat", readSockstat},
+	{"sockstat6", readSockstat},
+}
+
+// protocolCollector exports host-wide IP, TCP and UDP counters, so retransmissions, resets,
+// buffer drops or a TIME_WAIT build-up can be told apart without a separate node exporter
+type protocolCollector struct {
+	descs []*prometheus.Desc // by index into protocolStats
+}
+
+func newProtocolCollector() *protocolCollector {
+	c := &protocolCollector{}
+	for _, stat := range protocolStats {
+		var labels []string
+		if stat.label != "" {
+			labels = []string{stat.label}
+		}
+		c.descs = append(c.descs, prometheus.NewDesc(stat.name, stat.help, labels, nil))
+	}
+	return c
+}
+
+func (c *protocolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.descs {
+		ch <- desc
+	}
+}
+
+func (c *protocolCollector) Collect(ch chan<- prometheus.Metric) {
+	// The protocol names of the files do not overlap ("Tcp" in snmp, "TCP" in sockstat)
+	stats := make(map[string]map[string]float64)
+	for _, file := range protocolFiles {
+		path := procFilePath("net", file.name)
+		fileStats, err := file.parse(path)
+		if err != nil {
+			// snmp6 and sockstat6 are missing with IPv6 disabled
+			if !os.IsNotExist(err) {
+				log.Printf("Error reading %s: %v", path, err)
+				countError("protocol", "read")
+			}
+			continue
+		}
+		for proto, values := range fileStats {
+			stats[proto] = values
+		}
+	}
+
+	for i, stat := range protocolStats {
+		for _, source := range stat.sources {
+			v, ok := stats[source.proto][source.name]
+			if !ok {
+				// Counter not present in this kernel version
+				continue
+			}
+			if source.scale != 0 {
+				v *= source.scale
+			}
+			if stat.label == "" {
+				ch <- prometheus.MustNewConstMetric(c.descs[i], stat.valueType, v)
+			} else {
+				ch <- prometheus.MustNewConstMetric(c.descs[i], stat.valueType, v, source.labelValue)
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// socketQueueMetrics describes the receive and send queues of connected sockets. A
+// receive queue that keeps growing means the application stopped reading, e.g. because
+// its worker pool is stuck; a growing send queue means the peer or network is not keeping up.
+type socketQueueMetrics struct {
+	receive  *prometheus.Desc
+	send     *prometheus.Desc
+	minBytes uint64 // sockets with both queues at or below this are left out
+}
+
+// tcpQueueStates are the TCP states whose sockets can hold queued data. TIME_WAIT and CLOSE
+// sockets have none, connections still in the handshake have not exchanged any, and the
+// queues of LISTEN sockets are their accept queue, exported by the listen queue metrics.
+var tcpQueueStates = tcpStateBit("ESTABLISHED") | tcpStateBit("FIN_WAIT1") | tcpStateBit("FIN_WAIT2") |
+	tcpStateBit("CLOSE_WAIT") | tcpStateBit("CLOSING") | tcpStateBit("LAST_ACK")
+
+// newSocketQueueMetrics uses the per-socket connection labels, or with perSocket unset
+// the peer and service port (lifecycleLabels), summing the sockets behind each series
+func newSocketQueueMetrics(perSocket bool, minBytes uint64) *socketQueueMetrics {
+	labels := connectionLabels
+	if !perSocket {
+		labels = lifecycleLabels
+	}
+	return &socketQueueMetrics{
+		receive: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "receive_queue_bytes"),
+			"Bytes received but not yet read by the application",
+			labels,
+			nil,
+		),
+		send: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "send_queue_bytes"),
+			"Bytes written by the application but not yet sent or acknowledged by the peer",
+			labels,
+			nil,
+		),
+		minBytes: minBytes,
+	}
+}
+
+func (m *socketQueueMetrics) describe(ch chan<- *prometheus.Desc) {
+	ch <- m.receive
+	ch <- m.send
+}
+
+// socketQueues accumulates the queue metrics of one scrape
+type socketQueues struct {
+	metrics *socketQueueMetrics
+	receive *labelCounter
+	send    *labelCounter
+}
+
+func (m *socketQueueMetrics) newQueues() *socketQueues {
+	return &socketQueues{
+		metrics: m,
+		receive: newLabelCounter(),
+		send:    newLabelCounter(),
+	}
+}
+
+// add counts the queues of one socket under labelValues, unless neither exceeds the threshold
+func (q *socketQueues) add(conn tcpConnection, labelValues []string) {
+	if conn.rxQueue <= q.metrics.minBytes && conn.txQueue <= q.metrics.minBytes {
+		return
+	}
+	q.receive.add(float64(conn.rxQueue), labelValues...)
+	q.send.add(float64(conn.txQueue), labelValues...)
+}
+
+func (q *socketQueues) collect(ch chan<- prometheus.Metric) {
+	q.receive.collect(ch, q.metrics.receive)
+	q.send.collect(ch, q.metrics.send)
+}
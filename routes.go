@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// route is one entry of a routing table
+type route struct {
+	network *net.IPNet
+	iface   string
+	metric  uint32
+	table   uint32
+	kind    uint8 // RTN_* route type
+	gateway bool  // reached through a gateway rather than directly connected
+}
+
+// routeRule is a policy routing rule ("ip rule"), deciding which table a lookup uses
+type routeRule struct {
+	ipv6            bool
+	priority        uint32
+	src             *net.IPNet // nil matches any source
+	dst             *net.IPNet // nil matches any destination
+	iif             string
+	oif             string
+	fwmark          uint32
+	l3mdev          bool // "lookup l3mdev-table": use the table of the VRF the flow belongs to
+	unknownSelector bool // selects on something the exporter cannot evaluate
+	invert          bool
+	action          uint8
+	table           uint32
+	gotoPriority    uint32
+	suppressPrefix  int32 // routes with a prefix this short or shorter are ignored; -1 disables
+}
+
+// defaultRules are the kernel's rules without any policy routing configured
+var defaultRules = []routeRule{
+	{priority: 0, action: frActToTbl, table: syscall.RT_TABLE_LOCAL, suppressPrefix: -1},
+	{priority: 32766, action: frActToTbl, table: syscall.RT_TABLE_MAIN, suppressPrefix: -1},
+	{priority: 32767, action: frActToTbl, table: syscall.RT_TABLE_DEFAULT, suppressPrefix: -1},
+	{ipv6: true, priority: 0, action: frActToTbl, table: syscall.RT_TABLE_LOCAL, suppressPrefix: -1},
+	{ipv6: true, priority: 32766, action: frActToTbl, table: syscall.RT_TABLE_MAIN, suppressPrefix: -1},
+}
+
+// routeTable answers route lookups the way the kernel does: rules in priority order,
+// longest prefix match within each table
+type routeTable struct {
+	routes []route           // sorted by prefix length (longest first), then metric
+	rules  []routeRule       // sorted by priority
+	links  map[int32]linkMsg // by interface index; nil when read from /proc
+}
+
+// routeFlow is a locally originated packet as seen by the policy routing rules
+type routeFlow struct {
+	src      net.IP // nil when unknown
+	dst      net.IP
+	oif      string // device the socket is bound to, "" if unbound
+	vrfTable uint32 // table of the VRF the socket is bound to, 0 if none
+}
+
+// Route flags from linux/route.h
+const (
+	rtfUp      = 0x0001
+	rtfGateway = 0x0002
+	rtfReject  = 0x0200
+)
+
+// newRouteTable sorts routes and rules for lookups; without rules the kernel defaults apply
+func newRouteTable(routes []route, rules []routeRule) *routeTable {
+	if len(rules) == 0 {
+		rules = defaultRules
+	}
+	t := &routeTable{routes: routes, rules: rules}
+	sort.SliceStable(t.routes, func(i, j int) bool {
+		oi, _ := t.routes[i].network.Mask.Size()
+		oj, _ := t.routes[j].network.Mask.Size()
+		if oi != oj {
+			return oi > oj
+		}
+		return t.routes[i].metric < t.routes[j].metric
+	})
+	sort.SliceStable(t.rules, func(i, j int) bool {
+		return t.rules[i].priority < t.rules[j].priority
+	})
+	return t
+}
+
+// loadNamespaceRoutes reads every routing table and rule of a namespace over rtnetlink,
+// falling back to the main table from /proc when netlink is unavailable
+func loadNamespaceRoutes(ns netNamespace) *routeTable {
+	defer observeDuration("routes", time.Now())
+
+	links, err := dumpLinks(ns)
+	var routes []route
+	if err == nil {
+		routes, err = dumpRoutes(ns, links)
+	}
+	if err != nil {
+		log.Printf("Warning: Could not read routes of netns %s over netlink, using /proc: %v", ns.label(), err)
+		countError("routes", "netlink")
+		return loadRouteTable(ns.procNetFile("route"), ns.procNetFile("ipv6_route"))
+	}
+
+	// Kernels built without CONFIG_IP_MULTIPLE_TABLES have no rules to dump
+	rules, err := dumpRules(ns)
+	if err != nil {
+		log.Printf("Warning: Could not read routing rules of netns %s, assuming the defaults: %v", ns.label(), err)
+		countError("routes", "rules")
+	}
+	t := newRouteTable(routes, rules)
+	t.links = links
+	return t
+}
+
+// loadRouteTable reads the IPv4 and IPv6 main routing tables of a namespace from
+// /proc/<pid>/net/route and /proc/<pid>/net/ipv6_route; missing files are ignored
+func loadRouteTable(ipv4File, ipv6File string) *routeTable {
+	var routes []route
+	routes = append(routes, parseIPv4Routes(ipv4File)...)
+	routes = append(routes, parseIPv6Routes(ipv6File)...)
+	return newRouteTable(routes, nil)
+}
+
+// parseIPv4Routes parses /proc/net/route. Destination and mask are printed as
+// host-order hex words, like the addresses in /proc/net/tcp.
+func parseIPv4Routes(file string) []route {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var routes []route
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // Skip header line
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask MTU Window IRTT
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+		flags, _ := strconv.ParseUint(fields[3], 16, 32)
+		if flags&rtfUp == 0 || flags&rtfReject != 0 {
+			continue
+		}
+		dest, _, err := parseAddress(fields[1] + ":0")
+		if err != nil {
+			continue
+		}
+		mask, _, err := parseAddress(fields[7] + ":0")
+		if err != nil {
+			continue
+		}
+		metric, _ := strconv.ParseUint(fields[6], 10, 32)
+		routes = append(routes, route{
+			network: &net.IPNet{IP: net.ParseIP(dest).To4(), Mask: net.IPMask(net.ParseIP(mask).To4())},
+			iface:   fields[0],
+			metric:  uint32(metric),
+			table:   syscall.RT_TABLE_MAIN,
+			kind:    syscall.RTN_UNICAST,
+			gateway: flags&rtfGateway != 0,
+		})
+	}
+	return routes
+}
+
+// parseIPv6Routes parses /proc/net/ipv6_route, where addresses are plain network-order hex
+func parseIPv6Routes(file string) []route {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var routes []route
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// dest dest_plen src src_plen nexthop metric refcnt use flags iface
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		flags, _ := strconv.ParseUint(fields[8], 16, 32)
+		if flags&rtfUp == 0 || flags&rtfReject != 0 {
+			continue
+		}
+		dest, err := hex.DecodeString(fields[0])
+		if err != nil || len(dest) != net.IPv6len {
+			continue
+		}
+		prefixLen, err := strconv.ParseUint(fields[1], 16, 8)
+		if err != nil {
+			continue
+		}
+		metric, _ := strconv.ParseUint(fields[5], 16, 32)
+		routes = append(routes, route{
+			network: &net.IPNet{IP: net.IP(dest), Mask: net.CIDRMask(int(prefixLen), 128)},
+			iface:   fields[9],
+			metric:  uint32(metric),
+			table:   syscall.RT_TABLE_MAIN,
+			kind:    syscall.RTN_UNICAST,
+		})
+	}
+	return routes
+}
+
+// lookup returns the route the kernel would pick for traffic to ip from any source
+func (t *routeTable) lookup(ip net.IP) (route, bool) {
+	return t.resolve(routeFlow{dst: ip})
+}
+
+// resolve walks the policy routing rules for a flow and returns the route found, like
+// "ip route get <dst> from <src> oif <device>"
+func (t *routeTable) resolve(flow routeFlow) (route, bool) {
+	if flow.dst == nil {
+		return route{}, false
+	}
+	ipv6 := flow.dst.To4() == nil
+
+	for i := 0; i < len(t.rules); i++ {
+		rule := t.rules[i]
+		if rule.ipv6 != ipv6 || !rule.matches(flow) {
+			continue
+		}
+		switch rule.action {
+		case frActToTbl:
+			table := rule.table
+			if rule.l3mdev {
+				table = flow.vrfTable
+			}
+			r, ok := t.lookupTable(table, flow.dst)
+			if !ok {
+				continue
+			}
+			if ones, _ := r.network.Mask.Size(); rule.suppressPrefix >= 0 && ones <= int(rule.suppressPrefix) {
+				continue
+			}
+			switch r.kind {
+			case syscall.RTN_THROW:
+				continue
+			case syscall.RTN_BLACKHOLE, syscall.RTN_UNREACHABLE, syscall.RTN_PROHIBIT:
+				return route{}, false
+			}
+			return r, true
+		case frActGoto:
+			// Continue with the first rule at or after the target priority
+			for i+1 < len(t.rules) && t.rules[i+1].priority < rule.gotoPriority {
+				i++
+			}
+		case frActNop:
+		default:
+			// blackhole, unreachable and prohibit rules end the lookup
+			return route{}, false
+		}
+	}
+	return route{}, false
+}
+
+// matches reports whether a rule selects a locally originated flow. Such packets have
+// iif "lo", no output device unless the socket is bound, and mark 0.
+func (r routeRule) matches(flow routeFlow) bool {
+	if r.unknownSelector {
+		return false
+	}
+	match := (r.src == nil || (flow.src != nil && r.src.Contains(flow.src))) &&
+		(r.dst == nil || r.dst.Contains(flow.dst)) &&
+		(r.iif == "" || r.iif == "lo") &&
+		(r.oif == "" || r.oif == flow.oif) &&
+		(!r.l3mdev || flow.vrfTable != 0) &&
+		r.fwmark == 0
+	if r.invert {
+		return !match
+	}
+	return match
+}
+
+// lookupTable returns the most specific route containing ip in one table
+func (t *routeTable) lookupTable(table uint32, ip net.IP) (route, bool) {
+	for _, r := range t.routes {
+		if r.table != table {
+			continue
+		}
+		// Local addresses show up as /128 routes via lo in ipv6_route;
+		// skip those so an address resolves to the interface it lives on
+		if r.iface == "lo" && !ip.IsLoopback() {
+			continue
+		}
+		if (r.network.IP.To4() == nil) != (ip.To4() == nil) {
+			continue
+		}
+		if r.network.Contains(ip) {
+			return r, true
+		}
+	}
+	return route{}, false
+}
+
+// interfaceForConnection resolves a connection's interface from the routing table alone,
+// for namespaces whose interfaces are not visible to the exporter and for addresses the
+// interface registry does not know: the source address resolves through its connected
+// route, otherwise the route towards the destination is used
+func (t *routeTable) interfaceForConnection(sourceIP, destIP string) string {
+	if isLoopbackAddress(sourceIP) || isLoopbackAddress(destIP) {
+		return "lo"
+	}
+
+	if isUnspecifiedAddress(sourceIP) {
+		// Wildcard listener: only the default route contains the unspecified address.
+		// Dual-stack :: listeners also accept IPv4, so fall back to the IPv4 default.
+		for _, wildcard := range []string{sourceIP, "0.0.0.0"} {
+			if r, ok := t.lookup(net.ParseIP(wildcard)); ok && r.iface != "" {
+				return r.iface
+			}
+		}
+		return "unknown"
+	}
+
+	src := net.ParseIP(sourceIP)
+	if src != nil && !src.IsUnspecified() {
+		if r, ok := t.lookup(src); ok {
+			return r.iface
+		}
+	}
+	if r, ok := t.resolve(routeFlow{src: src, dst: net.ParseIP(destIP)}); ok {
+		return r.iface
+	}
+
+	return "unknown"
+}
+
+// socketInterface attributes a socket to an interface and VRF. Sockets bound to a device
+// (SO_BINDTODEVICE, "ip vrf exec", or accepted on a VRF listener) resolve through that
+// device and its VRF's table; the others are left to unbound, e.g. getInterfaceForConnection.
+func (t *routeTable) socketInterface(conn tcpConnection, unbound func(sourceIP, destIP string) string) (iface, vrf string) {
+	dev, ok := t.links[int32(conn.boundIfIndex)]
+	if conn.boundIfIndex == 0 || !ok {
+		iface = unbound(conn.sourceAddress, conn.destinationAddress)
+		return iface, t.vrfOfInterface(iface)
+	}
+	if dev.kind != "vrf" {
+		// Bound to a real device, which is where its traffic goes
+		return dev.name, t.vrfOfInterface(dev.name)
+	}
+
+	// Bound to the VRF itself: find the slave holding the source address, or the
+	// route towards the destination, in the VRF's table
+	flow := routeFlow{oif: dev.name, vrfTable: dev.vrfTable}
+	src := net.ParseIP(conn.sourceAddress)
+	if src != nil && !src.IsUnspecified() {
+		flow.dst = src
+		if r, ok := t.resolve(flow); ok && r.iface != "" {
+			return r.iface, dev.name
+		}
+	}
+	flow.src, flow.dst = src, net.ParseIP(conn.destinationAddress)
+	if flow.dst != nil && !flow.dst.IsUnspecified() {
+		if r, ok := t.resolve(flow); ok && r.iface != "" {
+			return r.iface, dev.name
+		}
+	}
+	// Wildcard listeners in a VRF accept on every slave
+	return dev.name, dev.name
+}
+
+// vrfOfInterface returns the VRF an interface is enslaved to, or "" if it is in the default VRF
+func (t *routeTable) vrfOfInterface(name string) string {
+	for _, link := range t.links {
+		if link.name != name {
+			continue
+		}
+		if link.kind == "vrf" {
+			return link.name
+		}
+		if master, ok := t.links[link.master]; ok && master.kind == "vrf" {
+			return master.name
+		}
+		return ""
+	}
+	return ""
+}
+
+// routeCacheMaxAge bounds how stale the cached table may get when route changes
+// cannot be monitored
+const routeCacheMaxAge = 30 * time.Second
+
+// routeCache holds the routing table of the exporter's own namespace. It is reloaded
+// only when the kernel announces a route, rule or link change.
+type routeCache struct {
+	mu        sync.Mutex
+	table     *routeTable
+	loaded    time.Time
+	monitored bool
+	dirty     atomic.Bool
+}
+
+// ownRoutes is the route cache of the exporter's own network namespace
+var ownRoutes = &routeCache{}
+
+// get returns the current table, reloading it if it changed
+func (c *routeCache) get() *routeTable {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.table == nil || c.dirty.Swap(false) || (!c.monitored && time.Since(c.loaded) > routeCacheMaxAge) {
+		c.table = loadNamespaceRoutes(netNamespace{})
+		c.loaded = time.Now()
+		routeTableReloads.Inc()
+	}
+	return c.table
+}
+
+// monitor subscribes to route, rule and link change notifications and marks the cache
+// dirty on each one; without notifications the table is reloaded every routeCacheMaxAge
+func (c *routeCache) monitor() {
+	fd, err := subscribeRtnetlink(rtmgrpLink | rtmgrpIPv4Route | rtmgrpIPv4Rule | rtmgrpIPv6Route | rtmgrpIPv6Rule)
+	if err != nil {
+		log.Printf("Warning: Could not subscribe to route changes, reloading routes every %s: %v", routeCacheMaxAge, err)
+		return
+	}
+	c.mu.Lock()
+	c.monitored = true
+	c.mu.Unlock()
+
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, os.Getpagesize())
+		for {
+			if _, _, err := syscall.Recvfrom(fd, buf, 0); err != nil {
+				if err == syscall.ENOBUFS {
+					// Notifications were dropped; some change happened
+					c.dirty.Store(true)
+					continue
+				}
+				if err == syscall.EINTR {
+					continue
+				}
+				log.Printf("Warning: Route change monitor stopped, reloading routes every %s: %v", routeCacheMaxAge, err)
+				c.mu.Lock()
+				c.monitored = false
+				c.mu.Unlock()
+				return
+			}
+			c.dirty.Store(true)
+		}
+	}()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+// mustCIDR parses a prefix such as "10.0.0.0/8"
+func mustCIDR(s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// unicast returns a unicast route in the given table
+func unicast(prefix, iface string, table uint32) route {
+	return route{network: mustCIDR(prefix), iface: iface, table: table, kind: syscall.RTN_UNICAST}
+}
+
+// withDefaultRules adds the kernel's default rules to custom ones
+func withDefaultRules(rules ...routeRule) []routeRule {
+	return append(append([]routeRule(nil), defaultRules...), rules...)
+}
+
+func TestRouteTableLookupTable(t *testing.T) {
+	mainTable := uint32(syscall.RT_TABLE_MAIN)
+	metric := func(r route, m uint32) route { r.metric = m; return r }
+	table := newRouteTable([]route{
+		unicast("0.0.0.0/0", "eth0", mainTable),
+		unicast("10.0.0.0/8", "eth1", mainTable),
+		unicast("10.1.0.0/16", "eth2", mainTable),
+		metric(unicast("172.16.0.0/24", "eth3", mainTable), 100),
+		metric(unicast("172.16.0.0/24", "eth4", mainTable), 50),
+		unicast("192.168.0.0/16", "wg0", 100),
+		unicast("::/0", "eth0", mainTable),
+		unicast("2001:db8::/32", "eth1", mainTable),
+		// ipv6_route lists local addresses as /128 routes via lo
+		unicast("2001:db8::1/128", "lo", mainTable),
+		unicast("::1/128", "lo", mainTable),
+	}, nil)
+
+	tests := []struct {
+		name  string
+		table uint32
+		ip    string
+		want  string
+	}{
+		{"longest prefix", mainTable, "10.1.2.3", "eth2"},
+		{"shorter prefix", mainTable, "10.2.0.1", "eth1"},
+		{"default route", mainTable, "8.8.8.8", "eth0"},
+		{"lowest metric", mainTable, "172.16.0.9", "eth4"},
+		{"other table only", 100, "192.168.1.1", "wg0"},
+		{"not in other table", 100, "10.1.2.3", ""},
+		{"ipv6 ignores ipv4 routes", mainTable, "2001:db9::1", "eth0"},
+		{"ipv6 prefix", mainTable, "2001:db8::5", "eth1"},
+		{"local address resolves to its interface", mainTable, "2001:db8::1", "eth1"},
+		{"loopback", mainTable, "::1", "lo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ok := table.lookupTable(tt.table, net.ParseIP(tt.ip))
+			if ok != (tt.want != "") || r.iface != tt.want {
+				t.Errorf("lookupTable(%d, %s) = %q, %v, want %q", tt.table, tt.ip, r.iface, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteRuleMatches(t *testing.T) {
+	flow := routeFlow{src: net.ParseIP("192.168.1.5"), dst: net.ParseIP("8.8.8.8")}
+	tests := []struct {
+		name string
+		rule routeRule
+		flow routeFlow
+		want bool
+	}{
+		{"no selectors", routeRule{}, flow, true},
+		{"from matching source", routeRule{src: mustCIDR("192.168.0.0/16")}, flow, true},
+		{"from other source", routeRule{src: mustCIDR("10.0.0.0/8")}, flow, false},
+		{"from with unknown source", routeRule{src: mustCIDR("192.168.0.0/16")}, routeFlow{dst: flow.dst}, false},
+		{"to matching destination", routeRule{dst: mustCIDR("8.8.8.0/24")}, flow, true},
+		{"to other destination", routeRule{dst: mustCIDR("1.1.1.0/24")}, flow, false},
+		{"not from other source", routeRule{src: mustCIDR("10.0.0.0/8"), invert: true}, flow, true},
+		{"not from matching source", routeRule{src: mustCIDR("192.168.0.0/16"), invert: true}, flow, false},
+		// Locally originated packets come from lo and carry no mark
+		{"iif lo", routeRule{iif: "lo"}, flow, true},
+		{"iif eth0", routeRule{iif: "eth0"}, flow, false},
+		{"fwmark", routeRule{fwmark: 0x1}, flow, false},
+		{"oif of bound socket", routeRule{oif: "vrf-blue"}, routeFlow{dst: flow.dst, oif: "vrf-blue"}, true},
+		{"oif of unbound socket", routeRule{oif: "vrf-blue"}, flow, false},
+		{"l3mdev in vrf", routeRule{l3mdev: true}, routeFlow{dst: flow.dst, vrfTable: 10}, true},
+		{"l3mdev outside vrf", routeRule{l3mdev: true}, flow, false},
+		{"unknown selector", routeRule{unknownSelector: true}, flow, false},
+		{"unknown selector inverted", routeRule{unknownSelector: true, invert: true}, flow, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.matches(tt.flow); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteTableResolve(t *testing.T) {
+	mainTable := uint32(syscall.RT_TABLE_MAIN)
+	mainRoutes := []route{
+		unicast("0.0.0.0/0", "eth0", mainTable),
+		unicast("10.0.0.0/8", "eth1", mainTable),
+		unicast("10.1.0.0/16", "eth2", mainTable),
+		{network: mustCIDR("203.0.113.0/24"), table: mainTable, kind: syscall.RTN_BLACKHOLE},
+		{network: mustCIDR("198.51.100.0/24"), table: mainTable, kind: syscall.RTN_UNREACHABLE},
+		unicast("::/0", "eth0", mainTable),
+	}
+	tunnel := []route{
+		unicast("0.0.0.0/0", "wg0", 100),
+		{network: mustCIDR("10.0.0.0/8"), table: 100, kind: syscall.RTN_THROW},
+	}
+	vrf := []route{
+		unicast("0.0.0.0/0", "eth3", 10),
+		unicast("172.16.0.0/24", "eth4", 10),
+	}
+	routes := append(append(append([]route(nil), mainRoutes...), tunnel...), vrf...)
+
+	lookup := func(priority, table uint32) routeRule {
+		return routeRule{priority: priority, action: frActToTbl, table: table, suppressPrefix: -1}
+	}
+	fromLAN := lookup(100, 100)
+	fromLAN.src = mustCIDR("192.168.0.0/16")
+	suppressDefault := lookup(90, mainTable)
+	suppressDefault.suppressPrefix = 0
+	l3mdev := routeRule{priority: 1000, action: frActToTbl, l3mdev: true, suppressPrefix: -1}
+	blackhole := routeRule{priority: 50, action: frActBlackhole, dst: mustCIDR("8.8.4.0/24"), suppressPrefix: -1}
+	skip := routeRule{priority: 10, action: frActGoto, gotoPriority: 200, suppressPrefix: -1}
+
+	lan := net.ParseIP("192.168.1.5")
+	tests := []struct {
+		name  string
+		rules []routeRule
+		flow  routeFlow
+		want  string // "" for no route
+	}{
+		{"default rules, longest prefix", nil, routeFlow{dst: net.ParseIP("10.1.2.3")}, "eth2"},
+		{"default rules, default route", nil, routeFlow{dst: net.ParseIP("8.8.8.8")}, "eth0"},
+		{"default rules, ipv6", nil, routeFlow{dst: net.ParseIP("2001:db8::1")}, "eth0"},
+		{"no destination", nil, routeFlow{}, ""},
+		{"blackhole route", nil, routeFlow{dst: net.ParseIP("203.0.113.7")}, ""},
+		{"unreachable route", nil, routeFlow{dst: net.ParseIP("198.51.100.7")}, ""},
+		{"source rule matches", withDefaultRules(fromLAN), routeFlow{src: lan, dst: net.ParseIP("8.8.8.8")}, "wg0"},
+		{"source rule skipped", withDefaultRules(fromLAN), routeFlow{dst: net.ParseIP("8.8.8.8")}, "eth0"},
+		// throw ends the lookup in this table and the next rule applies
+		{"throw route", withDefaultRules(fromLAN), routeFlow{src: lan, dst: net.ParseIP("10.1.2.3")}, "eth2"},
+		// Rule 100 comes before main (32766) by priority, not by position in the list
+		{"rule priority", append([]routeRule{lookup(32766, mainTable), lookup(32767, syscall.RT_TABLE_DEFAULT)}, fromLAN),
+			routeFlow{src: lan, dst: net.ParseIP("8.8.8.8")}, "wg0"},
+		{"goto skips rules", withDefaultRules(skip, fromLAN), routeFlow{src: lan, dst: net.ParseIP("8.8.8.8")}, "eth0"},
+		// "ip rule add table main suppress_prefixlength 0", as used by wg-quick
+		{"suppressed default route", withDefaultRules(suppressDefault, fromLAN), routeFlow{src: lan, dst: net.ParseIP("8.8.8.8")}, "wg0"},
+		{"specific route not suppressed", withDefaultRules(suppressDefault, fromLAN), routeFlow{src: lan, dst: net.ParseIP("10.1.2.3")}, "eth2"},
+		{"blackhole rule", withDefaultRules(blackhole), routeFlow{dst: net.ParseIP("8.8.4.4")}, ""},
+		{"blackhole rule other destination", withDefaultRules(blackhole), routeFlow{dst: net.ParseIP("8.8.8.8")}, "eth0"},
+		{"l3mdev vrf table", withDefaultRules(l3mdev), routeFlow{dst: net.ParseIP("172.16.0.9"), oif: "vrf-blue", vrfTable: 10}, "eth4"},
+		{"l3mdev vrf default route", withDefaultRules(l3mdev), routeFlow{dst: net.ParseIP("8.8.8.8"), oif: "vrf-blue", vrfTable: 10}, "eth3"},
+		{"l3mdev outside vrf", withDefaultRules(l3mdev), routeFlow{dst: net.ParseIP("172.16.0.9")}, "eth0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := newRouteTable(append([]route(nil), routes...), tt.rules)
+			r, ok := table.resolve(tt.flow)
+			if ok != (tt.want != "") || r.iface != tt.want {
+				t.Errorf("resolve(%+v) = %q, %v, want %q", tt.flow, r.iface, ok, tt.want)
+			}
+		})
+	}
+}
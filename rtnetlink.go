@@ -0,0 +1,402 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// rtnetlink message layouts and attributes from linux/rtnetlink.h and linux/fib_rules.h
+const (
+	sizeofRtMsg       = 12 // struct rtmsg
+	sizeofFibRuleHdr  = 12 // struct fib_rule_hdr
+	sizeofIfInfoMsg   = 16 // struct ifinfomsg
+	sizeofIfAddrMsg   = 8  // struct ifaddrmsg
+	sizeofRtNexthop   = 8  // struct rtnexthop
+	rtaTable          = 15 // RTA_TABLE, the full 32-bit table id
+	fraDst            = 1
+	fraSrc            = 2
+	fraIifName        = 3
+	fraGoto           = 4
+	fraPriority       = 6
+	fraFwmark         = 10
+	fraSuppressPrefix = 14
+	fraTable          = 15
+	fraOifName        = 17
+	fraL3mdev         = 19
+	fraUIDRange       = 20
+	fraIPProto        = 22
+	fraSportRange     = 23
+	fraDportRange     = 24
+	fibRuleInvert     = 0x2
+	iflaInfoKind      = 1 // IFLA_INFO_KIND inside IFLA_LINKINFO
+	iflaInfoData      = 2 // IFLA_INFO_DATA inside IFLA_LINKINFO
+	iflaVlanID        = 1 // IFLA_VLAN_ID inside IFLA_INFO_DATA of a vlan link
+	iflaVrfTable      = 1 // IFLA_VRF_TABLE inside IFLA_INFO_DATA of a vrf link
+
+	// Multicast groups (legacy bitmask) for link, address, route and rule changes
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4Ifaddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv4Rule   = 0x80
+	rtmgrpIPv6Ifaddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+	rtmgrpIPv6Rule   = 1 << (19 - 1) // RTNLGRP_IPV6_RULE
+)
+
+// Rule actions (FR_ACT_*)
+const (
+	frActToTbl       = 1
+	frActGoto        = 2
+	frActNop         = 3
+	frActBlackhole   = 6
+	frActUnreachable = 7
+	frActProhibit    = 8
+)
+
+// netlinkDump sends one dump request to a netlink protocol inside a network namespace
+// and returns the reply messages, without the terminating NLMSG_DONE
+func netlinkDump(ns netNamespace, protocol int, msgType uint16, body []byte) ([]syscall.NetlinkMessage, error) {
+	// Netlink sockets only see the namespace they were created in
+	fd := -1
+	err := inNetNamespace(ns, func() error {
+		var err error
+		fd, err = syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, protocol)
+		return err
+	})
+	if err != nil {
+		if fd >= 0 {
+			syscall.Close(fd)
+		}
+		return nil, err
+	}
+	defer syscall.Close(fd)
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	// struct nlmsghdr followed by the request body
+	req := make([]byte, syscall.NLMSG_HDRLEN+len(body))
+	binary.NativeEndian.PutUint32(req[0:4], uint32(len(req)))
+	binary.NativeEndian.PutUint16(req[4:6], msgType)
+	binary.NativeEndian.PutUint16(req[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP)
+	binary.NativeEndian.PutUint32(req[8:12], 1)
+	copy(req[syscall.NLMSG_HDRLEN:], body)
+
+	if err := syscall.Sendto(fd, req, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	var result []syscall.NetlinkMessage
+	buf := make([]byte, 8*os.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return result, nil
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) >= 4 {
+					if errno := int32(binary.NativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+						return nil, syscall.Errno(-errno)
+					}
+				}
+				return result, nil
+			}
+			// The receive buffer is reused, so keep a copy
+			m.Data = append([]byte(nil), m.Data...)
+			result = append(result, m)
+		}
+	}
+}
+
+// dumpLinks returns the links of a namespace by interface index
+func dumpLinks(ns netNamespace) (map[int32]linkMsg, error) {
+	msgs, err := netlinkDump(ns, syscall.NETLINK_ROUTE, syscall.RTM_GETLINK, make([]byte, sizeofIfInfoMsg))
+	if err != nil {
+		return nil, fmt.Errorf("link dump: %v", err)
+	}
+	links := make(map[int32]linkMsg)
+	for _, m := range msgs {
+		if link, ok := parseLinkMsg(m); ok {
+			links[link.index] = link
+		}
+	}
+	return links, nil
+}
+
+// linkMsg is a decoded RTM_NEWLINK/RTM_DELLINK message
+type linkMsg struct {
+	index    int32
+	name     string
+	flags    uint32 // IFF_* flags
+	mtu      uint32
+	master   int32  // ifindex of the bond, bridge or VRF the link is enslaved to, 0 if none
+	kind     string // link type from IFLA_INFO_KIND ("bond", "bridge", "vlan", "vrf", ...), "" for plain devices
+	vlanID   int    // 802.1Q VLAN ID of vlan links
+	vrfTable uint32 // routing table of vrf links
+}
+
+// parseLinkMsg decodes struct ifinfomsg and the name, MTU, master and link type attributes
+func parseLinkMsg(m syscall.NetlinkMessage) (linkMsg, bool) {
+	if (m.Header.Type != syscall.RTM_NEWLINK && m.Header.Type != syscall.RTM_DELLINK) || len(m.Data) < sizeofIfInfoMsg {
+		return linkMsg{}, false
+	}
+	link := linkMsg{
+		index: int32(binary.NativeEndian.Uint32(m.Data[4:8])),
+		flags: binary.NativeEndian.Uint32(m.Data[8:12]),
+	}
+	attrs := parseNetlinkAttrs(m.Data[sizeofIfInfoMsg:])
+	if name, ok := attrs[syscall.IFLA_IFNAME]; ok {
+		link.name = cString(name)
+	}
+	if b, ok := attrs[syscall.IFLA_MTU]; ok && len(b) == 4 {
+		link.mtu = binary.NativeEndian.Uint32(b)
+	}
+	if b, ok := attrs[syscall.IFLA_MASTER]; ok && len(b) == 4 {
+		link.master = int32(binary.NativeEndian.Uint32(b))
+	}
+	if b, ok := attrs[syscall.IFLA_LINKINFO]; ok {
+		info := parseNetlinkAttrs(b)
+		if kind, ok := info[iflaInfoKind]; ok {
+			link.kind = cString(kind)
+		}
+		if data, ok := info[iflaInfoData]; ok {
+			switch link.kind {
+			case "vlan":
+				if id, ok := parseNetlinkAttrs(data)[iflaVlanID]; ok && len(id) == 2 {
+					link.vlanID = int(binary.NativeEndian.Uint16(id))
+				}
+			case "vrf":
+				if table, ok := parseNetlinkAttrs(data)[iflaVrfTable]; ok && len(table) == 4 {
+					link.vrfTable = binary.NativeEndian.Uint32(table)
+				}
+			}
+		}
+	}
+	return link, true
+}
+
+// addrMsg is a decoded RTM_NEWADDR/RTM_DELADDR message
+type addrMsg struct {
+	index     int32
+	ip        net.IP
+	label     string // IPv4 address label, e.g. "eth0:gssapt11" for an aliased secondary address
+	secondary bool   // IPv4 address that is not the first one configured in its subnet
+}
+
+// parseAddrMsg decodes struct ifaddrmsg and the interface's own address. On IPv4
+// point-to-point links IFA_ADDRESS is the peer, so IFA_LOCAL is preferred.
+func parseAddrMsg(m syscall.NetlinkMessage) (addrMsg, bool) {
+	if (m.Header.Type != syscall.RTM_NEWADDR && m.Header.Type != syscall.RTM_DELADDR) || len(m.Data) < sizeofIfAddrMsg {
+		return addrMsg{}, false
+	}
+	attrs := parseNetlinkAttrs(m.Data[sizeofIfAddrMsg:])
+	b, ok := attrs[syscall.IFA_LOCAL]
+	if !ok {
+		b, ok = attrs[syscall.IFA_ADDRESS]
+	}
+	if !ok || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return addrMsg{}, false
+	}
+	addr := addrMsg{
+		index: int32(binary.NativeEndian.Uint32(m.Data[4:8])),
+		ip:    net.IP(append([]byte(nil), b...)),
+	}
+	// For IPv6 the same flag bit means a temporary (privacy) address, and there are no labels
+	if m.Data[0] == syscall.AF_INET {
+		addr.secondary = m.Data[2]&syscall.IFA_F_SECONDARY != 0
+		if label, ok := attrs[syscall.IFA_LABEL]; ok {
+			addr.label = cString(label)
+		}
+	}
+	return addr, true
+}
+
+// dumpAddrs returns the addresses of every interface in a namespace, both address families
+func dumpAddrs(ns netNamespace) ([]addrMsg, error) {
+	msgs, err := netlinkDump(ns, syscall.NETLINK_ROUTE, syscall.RTM_GETADDR, make([]byte, sizeofIfAddrMsg))
+	if err != nil {
+		return nil, fmt.Errorf("address dump: %v", err)
+	}
+	var addrs []addrMsg
+	for _, m := range msgs {
+		if addr, ok := parseAddrMsg(m); ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// dumpRoutes returns the routes of every table in a namespace, both address families
+func dumpRoutes(ns netNamespace, links map[int32]linkMsg) ([]route, error) {
+	var routes []route
+	for _, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+		body := make([]byte, sizeofRtMsg)
+		body[0] = family
+		msgs, err := netlinkDump(ns, syscall.NETLINK_ROUTE, syscall.RTM_GETROUTE, body)
+		if err != nil {
+			return nil, fmt.Errorf("route dump (family %d): %v", family, err)
+		}
+		for _, m := range msgs {
+			if r, ok := parseRouteMsg(m, links); ok {
+				routes = append(routes, r)
+			}
+		}
+	}
+	return routes, nil
+}
+
+// parseRouteMsg decodes an RTM_NEWROUTE message. Multipath routes resolve to their
+// first nexthop, which is enough to name the interface in the common case.
+func parseRouteMsg(m syscall.NetlinkMessage, links map[int32]linkMsg) (route, bool) {
+	if m.Header.Type != syscall.RTM_NEWROUTE || len(m.Data) < sizeofRtMsg {
+		return route{}, false
+	}
+	family, dstLen := m.Data[0], int(m.Data[1])
+	attrs := parseNetlinkAttrs(m.Data[sizeofRtMsg:])
+
+	bits := 8 * net.IPv4len
+	if family == syscall.AF_INET6 {
+		bits = 8 * net.IPv6len
+	}
+	dst := make(net.IP, bits/8)
+	if b, ok := attrs[syscall.RTA_DST]; ok && len(b) == len(dst) {
+		copy(dst, b)
+	}
+
+	r := route{
+		network: &net.IPNet{IP: dst, Mask: net.CIDRMask(dstLen, bits)},
+		table:   uint32(m.Data[4]),
+		kind:    m.Data[7],
+	}
+	if b, ok := attrs[rtaTable]; ok && len(b) == 4 {
+		r.table = binary.NativeEndian.Uint32(b)
+	}
+	if b, ok := attrs[syscall.RTA_PRIORITY]; ok && len(b) == 4 {
+		r.metric = binary.NativeEndian.Uint32(b)
+	}
+	if b, ok := attrs[syscall.RTA_OIF]; ok && len(b) == 4 {
+		r.iface = links[int32(binary.NativeEndian.Uint32(b))].name
+	} else if b, ok := attrs[syscall.RTA_MULTIPATH]; ok && len(b) >= sizeofRtNexthop {
+		// struct rtnexthop { len u16; flags u8; hops u8; ifindex s32; }
+		r.iface = links[int32(binary.NativeEndian.Uint32(b[4:8]))].name
+	}
+	return r, true
+}
+
+// dumpRules returns the policy routing rules of a namespace, both address families
+func dumpRules(ns netNamespace) ([]routeRule, error) {
+	var rules []routeRule
+	for _, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+		body := make([]byte, sizeofFibRuleHdr)
+		body[0] = family
+		msgs, err := netlinkDump(ns, syscall.NETLINK_ROUTE, syscall.RTM_GETRULE, body)
+		if err != nil {
+			return nil, fmt.Errorf("rule dump (family %d): %v", family, err)
+		}
+		for _, m := range msgs {
+			if rule, ok := parseRuleMsg(m); ok {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// parseRuleMsg decodes an RTM_NEWRULE message (struct fib_rule_hdr plus FRA_* attributes)
+func parseRuleMsg(m syscall.NetlinkMessage) (routeRule, bool) {
+	if m.Header.Type != syscall.RTM_NEWRULE || len(m.Data) < sizeofFibRuleHdr {
+		return routeRule{}, false
+	}
+	family := m.Data[0]
+	attrs := parseNetlinkAttrs(m.Data[sizeofFibRuleHdr:])
+
+	bits := 8 * net.IPv4len
+	if family == syscall.AF_INET6 {
+		bits = 8 * net.IPv6len
+	}
+	selector := func(attr uint16, prefixLen int) *net.IPNet {
+		b, ok := attrs[attr]
+		if !ok || prefixLen == 0 {
+			return nil
+		}
+		return &net.IPNet{IP: net.IP(b), Mask: net.CIDRMask(prefixLen, bits)}
+	}
+
+	rule := routeRule{
+		ipv6:           family == syscall.AF_INET6,
+		dst:            selector(fraDst, int(m.Data[1])),
+		src:            selector(fraSrc, int(m.Data[2])),
+		table:          uint32(m.Data[4]),
+		action:         m.Data[7],
+		invert:         binary.NativeEndian.Uint32(m.Data[8:12])&fibRuleInvert != 0,
+		suppressPrefix: -1,
+	}
+	if b, ok := attrs[fraPriority]; ok && len(b) == 4 {
+		rule.priority = binary.NativeEndian.Uint32(b)
+	}
+	if b, ok := attrs[fraTable]; ok && len(b) == 4 {
+		rule.table = binary.NativeEndian.Uint32(b)
+	}
+	if b, ok := attrs[fraGoto]; ok && len(b) == 4 {
+		rule.gotoPriority = binary.NativeEndian.Uint32(b)
+	}
+	if b, ok := attrs[fraSuppressPrefix]; ok && len(b) == 4 {
+		rule.suppressPrefix = int32(binary.NativeEndian.Uint32(b))
+	}
+	if b, ok := attrs[fraIifName]; ok {
+		rule.iif = cString(b)
+	}
+	if b, ok := attrs[fraOifName]; ok {
+		rule.oif = cString(b)
+	}
+	if b, ok := attrs[fraFwmark]; ok && len(b) == 4 {
+		rule.fwmark = binary.NativeEndian.Uint32(b)
+	}
+	if b, ok := attrs[fraL3mdev]; ok && len(b) == 1 {
+		rule.l3mdev = b[0] != 0
+	}
+	// Selectors on socket properties the exporter does not know (uid, ports, protocol)
+	// make the rule inapplicable to our lookups
+	for _, attr := range []uint16{fraUIDRange, fraIPProto, fraSportRange, fraDportRange} {
+		if _, ok := attrs[attr]; ok {
+			rule.unknownSelector = true
+		}
+	}
+	return rule, true
+}
+
+// cString converts a NUL-terminated netlink string attribute
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// subscribeRtnetlink opens a netlink socket in the exporter's own namespace that
+// receives the notifications of the given rtnetlink multicast groups
+func subscribeRtnetlink(groups uint32) (int, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return -1, err
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: groups}); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func ne32(v uint32) []byte { return binary.NativeEndian.AppendUint32(nil, v) }
+
+// rtMsg encodes an RTM_NEWROUTE message: struct rtmsg followed by the attributes
+func rtMsg(family, dstLen, table, kind uint8, attrs ...[]byte) syscall.NetlinkMessage {
+	b := []byte{family, dstLen, 0, 0, table, syscall.RTPROT_KERNEL, syscall.RT_SCOPE_UNIVERSE, kind, 0, 0, 0, 0}
+	for _, a := range attrs {
+		b = append(b, a...)
+	}
+	return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWROUTE}, Data: b}
+}
+
+// ruleMsg encodes an RTM_NEWRULE message: struct fib_rule_hdr followed by the attributes
+func ruleMsg(family, dstLen, srcLen, table, action uint8, flags uint32, attrs ...[]byte) syscall.NetlinkMessage {
+	b := []byte{family, dstLen, srcLen, 0, table, 0, 0, action}
+	b = binary.NativeEndian.AppendUint32(b, flags)
+	for _, a := range attrs {
+		b = append(b, a...)
+	}
+	return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWRULE}, Data: b}
+}
+
+func TestParseRouteMsg(t *testing.T) {
+	links := map[int32]linkMsg{2: {index: 2, name: "eth0"}, 3: {index: 3, name: "eth1"}}
+	// struct rtnexthop { len, flags, hops, ifindex }
+	nexthop := binary.NativeEndian.AppendUint16(nil, sizeofRtNexthop)
+	nexthop = append(nexthop, 0, 0)
+	nexthop = binary.NativeEndian.AppendUint32(nexthop, 3)
+
+	tests := []struct {
+		name    string
+		msg     syscall.NetlinkMessage
+		network string
+		iface   string
+		metric  uint32
+		table   uint32
+		kind    uint8
+	}{
+		{
+			name: "ipv4 connected",
+			msg: rtMsg(syscall.AF_INET, 24, syscall.RT_TABLE_MAIN, syscall.RTN_UNICAST,
+				nlattr(rtaTable, ne32(syscall.RT_TABLE_MAIN)), nlattr(syscall.RTA_DST, net.ParseIP("10.0.0.0").To4()),
+				nlattr(syscall.RTA_PRIORITY, ne32(100)), nlattr(syscall.RTA_OIF, ne32(2))),
+			network: "10.0.0.0/24", iface: "eth0", metric: 100, table: syscall.RT_TABLE_MAIN, kind: syscall.RTN_UNICAST,
+		},
+		{
+			// Default routes have no RTA_DST; tables above 255 are only in RTA_TABLE
+			name: "ipv4 default in table 1000",
+			msg: rtMsg(syscall.AF_INET, 0, syscall.RT_TABLE_COMPAT, syscall.RTN_UNICAST,
+				nlattr(rtaTable, ne32(1000)), nlattr(syscall.RTA_GATEWAY, net.ParseIP("10.0.0.1").To4()), nlattr(syscall.RTA_OIF, ne32(2))),
+			network: "0.0.0.0/0", iface: "eth0", table: 1000, kind: syscall.RTN_UNICAST,
+		},
+		{
+			name: "ipv6 multipath",
+			msg: rtMsg(syscall.AF_INET6, 0, syscall.RT_TABLE_MAIN, syscall.RTN_UNICAST,
+				nlattr(syscall.RTA_MULTIPATH, nexthop, nexthop)),
+			network: "::/0", iface: "eth1", table: syscall.RT_TABLE_MAIN, kind: syscall.RTN_UNICAST,
+		},
+		{
+			name: "ipv4 throw",
+			msg: rtMsg(syscall.AF_INET, 8, 100, syscall.RTN_THROW,
+				nlattr(syscall.RTA_DST, net.ParseIP("10.0.0.0").To4())),
+			network: "10.0.0.0/8", table: 100, kind: syscall.RTN_THROW,
+		},
+		{
+			name: "ipv6 blackhole",
+			msg: rtMsg(syscall.AF_INET6, 32, syscall.RT_TABLE_MAIN, syscall.RTN_BLACKHOLE,
+				nlattr(syscall.RTA_DST, net.ParseIP("2001:db8::"))),
+			network: "2001:db8::/32", table: syscall.RT_TABLE_MAIN, kind: syscall.RTN_BLACKHOLE,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ok := parseRouteMsg(tt.msg, links)
+			if !ok {
+				t.Fatal("parseRouteMsg() failed")
+			}
+			if r.network.String() != tt.network || r.iface != tt.iface || r.metric != tt.metric || r.table != tt.table || r.kind != tt.kind {
+				t.Errorf("parseRouteMsg() = %s dev %q metric %d table %d type %d, want %s dev %q metric %d table %d type %d",
+					r.network, r.iface, r.metric, r.table, r.kind, tt.network, tt.iface, tt.metric, tt.table, tt.kind)
+			}
+		})
+	}
+
+	short := rtMsg(syscall.AF_INET, 0, syscall.RT_TABLE_MAIN, syscall.RTN_UNICAST)
+	short.Data = short.Data[:sizeofRtMsg-1]
+	other := rtMsg(syscall.AF_INET, 0, syscall.RT_TABLE_MAIN, syscall.RTN_UNICAST)
+	other.Header.Type = syscall.RTM_DELROUTE
+	for name, msg := range map[string]syscall.NetlinkMessage{"short": short, "RTM_DELROUTE": other} {
+		if _, ok := parseRouteMsg(msg, links); ok {
+			t.Errorf("parseRouteMsg() of %s message succeeded", name)
+		}
+	}
+}
+
+func TestParseRuleMsg(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  syscall.NetlinkMessage
+		want routeRule
+	}{
+		{
+			name: "from prefix lookup table",
+			msg: ruleMsg(syscall.AF_INET, 0, 16, 100, frActToTbl, 0,
+				nlattr(fraPriority, ne32(100)), nlattr(fraSrc, net.ParseIP("192.168.0.0").To4()), nlattr(fraTable, ne32(100))),
+			want: routeRule{priority: 100, src: mustCIDR("192.168.0.0/16"), action: frActToTbl, table: 100, suppressPrefix: -1},
+		},
+		{
+			// "ip rule add not to 10.0.0.0/8 iif lo oif eth0 lookup 1000"
+			name: "inverted with devices",
+			msg: ruleMsg(syscall.AF_INET, 8, 0, syscall.RT_TABLE_COMPAT, frActToTbl, fibRuleInvert,
+				nlattr(fraPriority, ne32(200)), nlattr(fraDst, net.ParseIP("10.0.0.0").To4()), nlattr(fraTable, ne32(1000)),
+				nlattr(fraIifName, []byte("lo\x00")), nlattr(fraOifName, []byte("eth0\x00"))),
+			want: routeRule{priority: 200, dst: mustCIDR("10.0.0.0/8"), iif: "lo", oif: "eth0", invert: true, action: frActToTbl, table: 1000, suppressPrefix: -1},
+		},
+		{
+			name: "goto",
+			msg:  ruleMsg(syscall.AF_INET, 0, 0, 0, frActGoto, 0, nlattr(fraPriority, ne32(10)), nlattr(fraGoto, ne32(300))),
+			want: routeRule{priority: 10, action: frActGoto, gotoPriority: 300, suppressPrefix: -1},
+		},
+		{
+			name: "suppress_prefixlength",
+			msg: ruleMsg(syscall.AF_INET6, 0, 0, syscall.RT_TABLE_MAIN, frActToTbl, 0,
+				nlattr(fraPriority, ne32(32000)), nlattr(fraTable, ne32(syscall.RT_TABLE_MAIN)), nlattr(fraSuppressPrefix, ne32(0))),
+			want: routeRule{ipv6: true, priority: 32000, action: frActToTbl, table: syscall.RT_TABLE_MAIN, suppressPrefix: 0},
+		},
+		{
+			name: "l3mdev",
+			msg:  ruleMsg(syscall.AF_INET, 0, 0, 0, frActToTbl, 0, nlattr(fraPriority, ne32(1000)), nlattr(fraL3mdev, []byte{1})),
+			want: routeRule{priority: 1000, l3mdev: true, action: frActToTbl, suppressPrefix: -1},
+		},
+		{
+			name: "fwmark",
+			msg: ruleMsg(syscall.AF_INET, 0, 0, 200, frActToTbl, 0,
+				nlattr(fraPriority, ne32(500)), nlattr(fraFwmark, ne32(0x10))),
+			want: routeRule{priority: 500, fwmark: 0x10, action: frActToTbl, table: 200, suppressPrefix: -1},
+		},
+		{
+			name: "uidrange",
+			msg: ruleMsg(syscall.AF_INET, 0, 0, 200, frActToTbl, 0,
+				nlattr(fraPriority, ne32(600)), nlattr(fraUIDRange, ne32(1000), ne32(1999))),
+			want: routeRule{priority: 600, unknownSelector: true, action: frActToTbl, table: 200, suppressPrefix: -1},
+		},
+		{
+			name: "ipv6 unreachable to prefix",
+			msg: ruleMsg(syscall.AF_INET6, 32, 0, 0, frActUnreachable, 0,
+				nlattr(fraPriority, ne32(50)), nlattr(fraDst, net.ParseIP("2001:db8::"))),
+			want: routeRule{ipv6: true, priority: 50, dst: mustCIDR("2001:db8::/32"), action: frActUnreachable, suppressPrefix: -1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRuleMsg(tt.msg)
+			if !ok {
+				t.Fatal("parseRuleMsg() failed")
+			}
+			if got.src.String() != tt.want.src.String() || got.dst.String() != tt.want.dst.String() {
+				t.Errorf("parseRuleMsg() from %s to %s, want from %s to %s", got.src, got.dst, tt.want.src, tt.want.dst)
+			}
+			got.src, got.dst, tt.want.src, tt.want.dst = nil, nil, nil, nil
+			if got != tt.want {
+				t.Errorf("parseRuleMsg() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	short := ruleMsg(syscall.AF_INET, 0, 0, 0, frActToTbl, 0)
+	short.Data = short.Data[:sizeofFibRuleHdr-1]
+	if _, ok := parseRuleMsg(short); ok {
+		t.Error("parseRuleMsg() of a short message succeeded")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// version is the exporter version, set at build time with -ldflags "-X main.version=1.2.3"
+var version = "dev"
+
+// selfNamespace prefixes the metrics the exporter reports about itself
+const selfNamespace = "conn_exporter"
+
+var (
+	collectDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: selfNamespace,
+		Name:      "collect_duration_seconds",
+		Help:      "Time spent collecting from each source (tcp, udp, processes, netns, routes, cri, conntrack) and in total",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	}, []string{"source"})
+
+	socketsParsed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: selfNamespace,
+		Name:      "sockets_parsed_total",
+		Help:      "Number of sockets read from the socket backend",
+	}, []string{"source"})
+
+	collectErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: selfNamespace,
+		Name:      "errors_total",
+		Help:      "Number of errors while collecting, by source and reason",
+	}, []string{"source", "reason"})
+
+	interfaceCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: selfNamespace,
+		Name:      "interface_cache_lookups_total",
+		Help:      "Number of IP to interface lookups in the interface cache, by result (hit or miss)",
+	}, []string{"result"})
+
+	interfaceCacheRefreshes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: selfNamespace,
+		Name:      "interface_cache_refreshes_total",
+		Help:      "Number of times the interface registry was rebuilt from scratch",
+	})
+
+	interfaceChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: selfNamespace,
+		Name:      "interface_changes_total",
+		Help:      "Number of interface changes received from netlink, by event (link_added, link_changed, link_removed, address_added, address_changed, address_removed)",
+	}, []string{"event"})
+
+	interfaceDiscoveryMethod = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: selfNamespace,
+		Name:      "interface_discovery_method",
+		Help:      "Method used for the last interface discovery (netlink, sysfs or none), 1 for the active one",
+	}, []string{"method"})
+
+	routeTableReloads = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: selfNamespace,
+		Name:      "route_table_reloads_total",
+		Help:      "Number of times the exporter's own routing tables were reloaded",
+	})
+
+	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: selfNamespace,
+		Name:      "build_info",
+		Help:      "Build information of the exporter, always 1",
+	}, []string{"version", "revision", "goversion"})
+)
+
+// registerSelfMetrics registers the exporter's own metrics. The Go runtime and process
+// collectors (go_*, process_*) come with the default registry.
+func registerSelfMetrics() {
+	buildInfo.WithLabelValues(version, buildRevision(), runtime.Version()).Set(1)
+	prometheus.MustRegister(collectDuration, socketsParsed, collectErrors, interfaceCacheLookups, interfaceCacheRefreshes, interfaceChanges, interfaceDiscoveryMethod, routeTableReloads, buildInfo)
+}
+
+// buildRevision returns the VCS revision embedded by the go toolchain, if any
+func buildRevision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
+
+// setInterfaceDiscoveryMethod marks the interface discovery method in use
+func setInterfaceDiscoveryMethod(method string) {
+	for _, m := range []string{"netlink", "sysfs", "none"} {
+		value := 0.0
+		if m == method {
+			value = 1
+		}
+		interfaceDiscoveryMethod.WithLabelValues(m).Set(value)
+	}
+}
+
+// observeDuration records the time spent on a source since start
+func observeDuration(source string, start time.Time) {
+	collectDuration.WithLabelValues(source).Observe(time.Since(start).Seconds())
+}
+
+// countError records a collection error; the reason is a short fixed string such as "read" or "parse"
+func countError(source, reason string) {
+	collectErrors.WithLabelValues(source, reason).Inc()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// snapshotCollector serves the metrics of another collector from a snapshot refreshed
+// in the background, so concurrent scrapes (e.g. several Prometheus replicas) share
+// one walk of /proc instead of each paying for it and risking scrape timeouts
+type snapshotCollector struct {
+	collector prometheus.Collector
+	interval  time.Duration
+	age       *prometheus.Desc
+
+	mu      sync.RWMutex
+	metrics []prometheus.Metric
+	taken   time.Time
+}
+
+func newSnapshotCollector(collector prometheus.Collector, interval time.Duration) *snapshotCollector {
+	return &snapshotCollector{
+		collector: collector,
+		interval:  interval,
+		age: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "snapshot_age_seconds"),
+			"Time since the served connection snapshot was taken",
+			nil,
+			nil,
+		),
+	}
+}
+
+// start takes the first snapshot, so the first scrape is not empty, and then keeps
+// refreshing it every interval
+func (s *snapshotCollector) start() {
+	s.refresh()
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.refresh()
+		}
+	}()
+}
+
+// refresh runs the wrapped collector and replaces the snapshot
+func (s *snapshotCollector) refresh() {
+	ch := make(chan prometheus.Metric, 1024)
+	go func() {
+		s.collector.Collect(ch)
+		close(ch)
+	}()
+
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+
+	s.mu.Lock()
+	s.metrics = metrics
+	s.taken = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *snapshotCollector) Describe(ch chan<- *prometheus.Desc) {
+	s.collector.Describe(ch)
+	ch <- s.age
+}
+
+func (s *snapshotCollector) Collect(ch chan<- prometheus.Metric) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, m := range s.metrics {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(s.age, prometheus.GaugeValue, time.Since(s.taken).Seconds())
+}
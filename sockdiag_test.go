@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// diagMsg encodes a struct inet_diag_msg followed by the given attributes
+func diagMsg(family, state uint8, src, dst string, sport, dport uint16, rqueue, wqueue, inode uint32, attrs ...[]byte) []byte {
+	b := make([]byte, sizeofInetDiagMsg)
+	b[0], b[1] = family, state
+	binary.BigEndian.PutUint16(b[4:6], sport)
+	binary.BigEndian.PutUint16(b[6:8], dport)
+	srcIP, dstIP := net.ParseIP(src), net.ParseIP(dst)
+	if family == syscall.AF_INET {
+		copy(b[8:12], srcIP.To4())
+		copy(b[24:28], dstIP.To4())
+	} else {
+		copy(b[8:24], srcIP.To16())
+		copy(b[24:40], dstIP.To16())
+	}
+	binary.NativeEndian.PutUint32(b[40:44], 3) // ifindex
+	binary.NativeEndian.PutUint32(b[56:60], rqueue)
+	binary.NativeEndian.PutUint32(b[60:64], wqueue)
+	binary.NativeEndian.PutUint32(b[64:68], 1000) // uid
+	binary.NativeEndian.PutUint32(b[68:72], inode)
+	for _, a := range attrs {
+		b = append(b, a...)
+	}
+	return b
+}
+
+func TestParseInetDiagMsg(t *testing.T) {
+	tests := []struct {
+		name                string
+		data                []byte
+		src, dst            string
+		srcPort, dstPort    uint16
+		rqueue, wqueue, uid uint32
+		inode, ifIndex      uint32
+	}{
+		{
+			name: "ipv4", data: diagMsg(syscall.AF_INET, 1, "10.0.0.5", "93.184.216.34", 51234, 443, 10, 20, 12345),
+			src: "10.0.0.5", dst: "93.184.216.34", srcPort: 51234, dstPort: 443,
+			rqueue: 10, wqueue: 20, uid: 1000, inode: 12345, ifIndex: 3,
+		},
+		{
+			name: "ipv6", data: diagMsg(syscall.AF_INET6, 1, "2001:db8::1", "2001:db8::2", 48000, 80, 0, 512, 777),
+			src: "2001:db8::1", dst: "2001:db8::2", srcPort: 48000, dstPort: 80,
+			wqueue: 512, uid: 1000, inode: 777, ifIndex: 3,
+		},
+		{
+			// Dual-stack sockets report IPv4 peers as mapped addresses, shown dotted as in /proc
+			name: "ipv4-mapped", data: diagMsg(syscall.AF_INET6, 1, "::ffff:192.168.1.10", "::ffff:192.168.1.20", 8080, 40000, 0, 0, 42),
+			src: "192.168.1.10", dst: "192.168.1.20", srcPort: 8080, dstPort: 40000,
+			uid: 1000, inode: 42, ifIndex: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := parseInetDiagMsg(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.src.String() != tt.src || msg.dst.String() != tt.dst || msg.srcPort != tt.srcPort || msg.dstPort != tt.dstPort {
+				t.Errorf("tuple = %s:%d -> %s:%d, want %s:%d -> %s:%d", msg.src, msg.srcPort, msg.dst, msg.dstPort, tt.src, tt.srcPort, tt.dst, tt.dstPort)
+			}
+			if msg.rqueue != tt.rqueue || msg.wqueue != tt.wqueue || msg.uid != tt.uid || msg.inode != tt.inode || msg.ifIndex != tt.ifIndex {
+				t.Errorf("rqueue, wqueue, uid, inode, ifindex = %d, %d, %d, %d, %d, want %d, %d, %d, %d, %d",
+					msg.rqueue, msg.wqueue, msg.uid, msg.inode, msg.ifIndex, tt.rqueue, tt.wqueue, tt.uid, tt.inode, tt.ifIndex)
+			}
+		})
+	}
+}
+
+func TestParseInetDiagMsgShort(t *testing.T) {
+	if _, err := parseInetDiagMsg(make([]byte, sizeofInetDiagMsg-1)); err == nil {
+		t.Error("parseInetDiagMsg() of a truncated message succeeded")
+	}
+}
+
+func TestInetDiagTCPConnection(t *testing.T) {
+	info := make([]byte, 104)
+	binary.NativeEndian.PutUint32(info[80:84], 10) // snd_cwnd
+
+	tests := []struct {
+		name          string
+		data          []byte
+		state         string
+		rxQueue       uint64
+		txQueue       uint64
+		listenBacklog uint64
+		tcpInfo       bool
+	}{
+		{
+			// For listeners rqueue is the accept queue and wqueue its capacity
+			name:    "listen",
+			data:    diagMsg(syscall.AF_INET, 10, "0.0.0.0", "0.0.0.0", 443, 0, 3, 4096, 100),
+			state:   "LISTEN",
+			rxQueue: 3, listenBacklog: 4096,
+		},
+		{
+			name:    "established with tcp_info",
+			data:    diagMsg(syscall.AF_INET6, 1, "2001:db8::1", "2001:db8::2", 48000, 80, 5, 7, 101, nlattr(inetDiagInfo, info)),
+			state:   "ESTABLISHED",
+			rxQueue: 5, txQueue: 7, tcpInfo: true,
+		},
+		{
+			// Kernels older than the fields parseTCPInfo needs send a shorter struct
+			name:  "truncated tcp_info",
+			data:  diagMsg(syscall.AF_INET, 1, "10.0.0.5", "10.0.0.6", 40000, 22, 0, 0, 102, nlattr(inetDiagInfo, info[:100])),
+			state: "ESTABLISHED",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := parseInetDiagMsg(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn := msg.tcpConnection()
+			if conn.state != tt.state || conn.rxQueue != tt.rxQueue || conn.txQueue != tt.txQueue || conn.listenBacklog != tt.listenBacklog {
+				t.Errorf("state, rx, tx, backlog = %s, %d, %d, %d, want %s, %d, %d, %d",
+					conn.state, conn.rxQueue, conn.txQueue, conn.listenBacklog, tt.state, tt.rxQueue, tt.txQueue, tt.listenBacklog)
+			}
+			if (conn.tcpInfo != nil) != tt.tcpInfo {
+				t.Fatalf("tcpInfo = %+v, want present %v", conn.tcpInfo, tt.tcpInfo)
+			}
+			if tt.tcpInfo && conn.tcpInfo.sndCwnd != 10 {
+				t.Errorf("sndCwnd = %d, want 10", conn.tcpInfo.sndCwnd)
+			}
+		})
+	}
+}
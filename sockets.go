@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// socketSource is a backend that lists the sockets on the host. Both backends
+// return the same tcpConnection records; interface and process attribution is
+// done afterwards by the collector so it behaves the same for every backend.
+type socketSource interface {
+	// Name identifies the backend in log messages
+	Name() string
+	// TCPSockets returns IPv4 and IPv6 TCP sockets of a network namespace whose state is in the states bitmask
+	TCPSockets(ns netNamespace, states uint32) ([]tcpConnection, error)
+	// UDPSockets returns IPv4 and IPv6 UDP sockets of a network namespace
+	UDPSockets(ns netNamespace) ([]tcpConnection, error)
+}
+
+// newSocketSource returns the backend selected by --collector.backend, falling back
+// to /proc parsing when netlink sock_diag is unavailable (e.g. inet_diag not loaded)
+func newSocketSource(name string, withTCPInfo bool) socketSource {
+	switch name {
+	case "netlink":
+		source := &netlinkSocketSource{withTCPInfo: withTCPInfo}
+		if err := source.probe(); err != nil {
+			log.Printf("Warning: netlink sock_diag backend unavailable, falling back to /proc parsing: %v", err)
+			return &procSocketSource{}
+		}
+		log.Printf("Using netlink sock_diag socket backend")
+		return source
+	case "proc", "":
+		return &procSocketSource{}
+	default:
+		log.Printf("Warning: unknown collector backend %q, using /proc parsing", name)
+		return &procSocketSource{}
+	}
+}
+
+// procSocketSource reads sockets from the /proc/net text tables
+type procSocketSource struct{}
+
+func (s *procSocketSource) Name() string {
+	return "proc"
+}
+
+func (s *procSocketSource) TCPSockets(ns netNamespace, states uint32) ([]tcpConnection, error) {
+	var connections []tcpConnection
+	for _, file := range []string{ns.procNetFile("tcp"), ns.procNetFile("tcp6")} {
+		tcpConnections, err := getTCPConnections(file)
+		if err != nil {
+			logProcNetError("tcp", file, err)
+			continue
+		}
+		// /proc has no kernel-side filtering, so apply the state filter here
+		for _, conn := range tcpConnections {
+			if states&tcpStateBit(conn.state) != 0 {
+				connections = append(connections, conn)
+			}
+		}
+	}
+	return connections, nil
+}
+
+func (s *procSocketSource) UDPSockets(ns netNamespace) ([]tcpConnection, error) {
+	var connections []tcpConnection
+	for _, file := range []string{ns.procNetFile("udp"), ns.procNetFile("udp6")} {
+		udpConnections, err := getUDPConnections(file)
+		if err != nil {
+			logProcNetError("udp", file, err)
+			continue
+		}
+		connections = append(connections, udpConnections...)
+	}
+	return connections, nil
+}
+
+// logProcNetError logs a failure to read a /proc/net socket table; a missing
+// tcp6/udp6 file just means IPv6 is disabled on this host and is not an error
+func logProcNetError(source, file string, err error) {
+	if os.IsNotExist(err) {
+		return
+	}
+	log.Printf("Error getting connections from %s: %v", file, err)
+	countError(source, "read")
+}
+
+// tcpStatesAll selects every TCP state (ESTABLISHED=1 through CLOSING=11)
+const tcpStatesAll uint32 = 0xffe
+
+// tcpStateBit returns the sock_diag state bitmask bit for a state name such as "ESTABLISHED"
+func tcpStateBit(state string) uint32 {
+	for i := 1; i <= 11; i++ {
+		if connectionState(fmt.Sprintf("%02X", i)) == state {
+			return 1 << uint(i)
+		}
+	}
+	return 0
+}
+
+// parseTCPStates converts a comma-separated list of state names into a state bitmask;
+// an empty list selects all states
+func parseTCPStates(list string) (uint32, error) {
+	if strings.TrimSpace(list) == "" {
+		return tcpStatesAll, nil
+	}
+
+	var states uint32
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		bit := tcpStateBit(name)
+		if bit == 0 {
+			return 0, fmt.Errorf("unknown TCP state %q", name)
+		}
+		states |= bit
+	}
+	return states, nil
+}
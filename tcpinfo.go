@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// tcpInfo holds the struct tcp_info fields exported per connection
+type tcpInfo struct {
+	rtt           float64 // smoothed RTT in seconds
+	rttVar        float64 // RTT variance in seconds
+	sndCwnd       uint32
+	unacked       uint32
+	totalRetrans  uint32
+	bytesReceived uint64
+	bytesSent     uint64
+	deliveryRate  uint64 // bytes per second
+	hasBytes      bool   // bytes_received (kernel 4.1+)
+	hasBytesSent  bool   // bytes_sent (kernel 4.19+)
+	hasDelivery   bool   // delivery_rate (kernel 4.9+)
+}
+
+// parseTCPInfo decodes the INET_DIAG_INFO payload. The kernel only sends the
+// part of struct tcp_info it knows about, so newer fields are length-checked.
+func parseTCPInfo(b []byte) *tcpInfo {
+	// Offsets from struct tcp_info in linux/tcp.h
+	if len(b) < 104 {
+		return nil
+	}
+
+	info := &tcpInfo{
+		unacked:      binary.NativeEndian.Uint32(b[24:28]),
+		rtt:          float64(binary.NativeEndian.Uint32(b[68:72])) / 1e6,
+		rttVar:       float64(binary.NativeEndian.Uint32(b[72:76])) / 1e6,
+		sndCwnd:      binary.NativeEndian.Uint32(b[80:84]),
+		totalRetrans: binary.NativeEndian.Uint32(b[100:104]),
+	}
+	if len(b) >= 136 {
+		info.bytesReceived = binary.NativeEndian.Uint64(b[128:136])
+		info.hasBytes = true
+	}
+	if len(b) >= 168 {
+		info.deliveryRate = binary.NativeEndian.Uint64(b[160:168])
+		info.hasDelivery = true
+	}
+	if len(b) >= 208 {
+		info.bytesSent = binary.NativeEndian.Uint64(b[200:208])
+		info.hasBytesSent = true
+	}
+	return info
+}
+
+// tcpInfoMetrics describes the optional per-connection TCP_INFO metrics
+type tcpInfoMetrics struct {
+	rtt           *prometheus.Desc
+	rttVar        *prometheus.Desc
+	sndCwnd       *prometheus.Desc
+	retransmits   *prometheus.Desc
+	unacked       *prometheus.Desc
+	bytesSent     *prometheus.Desc
+	bytesReceived *prometheus.Desc
+	deliveryRate  *prometheus.Desc
+}
+
+func newTCPInfoMetrics() *tcpInfoMetrics {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, connectionLabels, nil)
+	}
+	return &tcpInfoMetrics{
+		rtt:           desc("tcp_rtt_seconds", "Smoothed round-trip time of the TCP connection"),
+		rttVar:        desc("tcp_rtt_variance_seconds", "Round-trip time variance of the TCP connection"),
+		sndCwnd:       desc("tcp_congestion_window_segments", "Sending congestion window of the TCP connection"),
+		retransmits:   desc("tcp_retransmits_total", "Total segments retransmitted on the TCP connection"),
+		unacked:       desc("tcp_unacked_segments", "Segments sent but not yet acknowledged on the TCP connection"),
+		bytesSent:     desc("tcp_sent_bytes_total", "Bytes sent on the TCP connection, including retransmissions"),
+		bytesReceived: desc("tcp_received_bytes_total", "Bytes received on the TCP connection"),
+		deliveryRate:  desc("tcp_delivery_rate_bytes_per_second", "Most recent delivery rate estimate of the TCP connection"),
+	}
+}
+
+func (m *tcpInfoMetrics) describe(ch chan<- *prometheus.Desc) {
+	ch <- m.rtt
+	ch <- m.rttVar
+	ch <- m.sndCwnd
+	ch <- m.retransmits
+	ch <- m.unacked
+	ch <- m.bytesSent
+	ch <- m.bytesReceived
+	ch <- m.deliveryRate
+}
+
+func (m *tcpInfoMetrics) collect(ch chan<- prometheus.Metric, info *tcpInfo, labelValues []string) {
+	ch <- prometheus.MustNewConstMetric(m.rtt, prometheus.GaugeValue, info.rtt, labelValues...)
+	ch <- prometheus.MustNewConstMetric(m.rttVar, prometheus.GaugeValue, info.rttVar, labelValues...)
+	ch <- prometheus.MustNewConstMetric(m.sndCwnd, prometheus.GaugeValue, float64(info.sndCwnd), labelValues...)
+	ch <- prometheus.MustNewConstMetric(m.retransmits, prometheus.CounterValue, float64(info.totalRetrans), labelValues...)
+	ch <- prometheus.MustNewConstMetric(m.unacked, prometheus.GaugeValue, float64(info.unacked), labelValues...)
+	if info.hasBytesSent {
+		ch <- prometheus.MustNewConstMetric(m.bytesSent, prometheus.CounterValue, float64(info.bytesSent), labelValues...)
+	}
+	if info.hasBytes {
+		ch <- prometheus.MustNewConstMetric(m.bytesReceived, prometheus.CounterValue, float64(info.bytesReceived), labelValues...)
+	}
+	if info.hasDelivery {
+		ch <- prometheus.MustNewConstMetric(m.deliveryRate, prometheus.GaugeValue, float64(info.deliveryRate), labelValues...)
+	}
+}
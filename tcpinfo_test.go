@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseTCPInfo(t *testing.T) {
+	// struct tcp_info as sent by a 4.19+ kernel
+	full := make([]byte, 232)
+	binary.NativeEndian.PutUint32(full[24:28], 4)        // unacked
+	binary.NativeEndian.PutUint32(full[68:72], 1500)     // rtt, microseconds
+	binary.NativeEndian.PutUint32(full[72:76], 250)      // rttvar
+	binary.NativeEndian.PutUint32(full[80:84], 10)       // snd_cwnd
+	binary.NativeEndian.PutUint32(full[100:104], 7)      // total_retrans
+	binary.NativeEndian.PutUint64(full[128:136], 1<<20)  // bytes_received
+	binary.NativeEndian.PutUint64(full[160:168], 125000) // delivery_rate
+	binary.NativeEndian.PutUint64(full[200:208], 3<<20)  // bytes_sent
+	base := tcpInfo{rtt: 0.0015, rttVar: 0.00025, sndCwnd: 10, unacked: 4, totalRetrans: 7}
+
+	tests := []struct {
+		name   string
+		length int
+		want   *tcpInfo
+	}{
+		{"truncated", 103, nil},
+		{"3.x", 104, &base},
+		{"4.1", 136, &tcpInfo{rtt: 0.0015, rttVar: 0.00025, sndCwnd: 10, unacked: 4, totalRetrans: 7,
+			bytesReceived: 1 << 20, hasBytes: true}},
+		{"4.9", 168, &tcpInfo{rtt: 0.0015, rttVar: 0.00025, sndCwnd: 10, unacked: 4, totalRetrans: 7,
+			bytesReceived: 1 << 20, hasBytes: true, deliveryRate: 125000, hasDelivery: true}},
+		{"4.19", 232, &tcpInfo{rtt: 0.0015, rttVar: 0.00025, sndCwnd: 10, unacked: 4, totalRetrans: 7,
+			bytesReceived: 1 << 20, hasBytes: true, deliveryRate: 125000, hasDelivery: true, bytesSent: 3 << 20, hasBytesSent: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseTCPInfo(full[:tt.length])
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("parseTCPInfo() = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil || *got != *tt.want {
+				t.Errorf("parseTCPInfo() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}